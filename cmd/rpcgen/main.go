@@ -0,0 +1,444 @@
+// rpcgen 根据Go接口生成服务端注册代码和客户端代码.
+//
+// 接口中的方法对应服务的函数:
+//
+//	caller:   Charge(ctx context.Context, in *ChargeReq) (*ChargeRes, error)
+//	notifier: Refunded(ctx context.Context, in *RefundEvent) error
+//
+// 用法:
+//
+//	//go:generate go run gitlab.forceup.in/zengliang/rpc2-center/cmd/rpcgen -file $GOFILE -type Payment -version v1
+//
+// 生成的文件中包含:
+//
+//	函数名常量 PaymentChargeFunction = "charge"
+//	方法变量 PaymentChargeMethod = common.NewMethod(PaymentVersion, PaymentName, PaymentChargeFunction)
+//	RegisterPayment(ag *rpc.ApiInfoGroup, impl Payment) error
+//	PaymentClient, 通过Node.Invoke/Node.InvokeNotify调用
+package main
+
+import (
+	"bytes"
+	"flag"
+	"fmt"
+	"go/ast"
+	"go/build"
+	"go/format"
+	"go/parser"
+	"go/token"
+	"go/types"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"text/template"
+	"unicode"
+)
+
+const (
+	commonImport = "gitlab.forceup.in/zengliang/rpc2-center/common"
+	rpcImport    = "gitlab.forceup.in/zengliang/rpc2-center/rpc"
+)
+
+type (
+	method struct {
+		Name     string
+		Function string // 小写的函数名
+		In       string
+		Out      string // 为空时是notifier
+		OutElem  string // Out是指针时的元素类型
+	}
+
+	service struct {
+		Package string
+		Type    string
+		Version string
+		Name    string
+		Common  string // common包在生成文件中的名字, 与用户的包重名时使用别名
+		Rpc     string // rpc包在生成文件中的名字
+		Imports []string
+		Methods []method
+	}
+
+	// 方法签名中引用的包名和没有包名的类型名
+	refs struct {
+		packages map[string]bool
+		idents   map[string]bool
+	}
+)
+
+var tmpl = template.Must(template.New("rpcgen").Parse(`// Code generated by rpcgen. DO NOT EDIT.
+
+package {{.Package}}
+
+import (
+{{- range .Imports}}
+	{{.}}
+{{- end}}
+)
+
+const (
+	{{.Type}}Version = "{{.Version}}"
+	{{.Type}}Name    = "{{.Name}}"
+{{range .Methods}}
+	{{$.Type}}{{.Name}}Function = "{{.Function}}"
+{{- end}}
+)
+
+var (
+{{- range .Methods}}
+	{{$.Type}}{{.Name}}Method = {{$.Common}}.NewMethod({{$.Type}}Version, {{$.Type}}Name, {{$.Type}}{{.Name}}Function)
+{{- end}}
+)
+
+// 把impl的方法注册为{{.Version}}.{{.Name}}的函数
+func Register{{.Type}}(ag *{{.Rpc}}.ApiInfoGroup, impl {{.Type}}) error {
+{{- range .Methods}}
+	if err := ag.RegisterTyped({{$.Type}}{{.Name}}Function, impl.{{.Name}}); err != nil {
+		return err
+	}
+{{- end}}
+	return nil
+}
+
+// {{.Version}}.{{.Name}}的客户端
+type {{.Type}}Client struct {
+	node *{{.Rpc}}.Node
+}
+
+func New{{.Type}}Client(node *{{.Rpc}}.Node) *{{.Type}}Client {
+	return &{{.Type}}Client{node: node}
+}
+{{range .Methods}}
+{{- if .Out}}
+func (c *{{$.Type}}Client) {{.Name}}(ctx context.Context, in {{.In}}, opts ...{{$.Rpc}}.InvokeOption) ({{.Out}}, error) {
+{{- if .OutElem}}
+	out := new({{.OutElem}})
+	if err := c.node.Invoke(ctx, {{$.Type}}{{.Name}}Method, in, out, opts...); err != nil {
+		return nil, err
+	}
+{{- else}}
+	var out {{.Out}}
+	if err := c.node.Invoke(ctx, {{$.Type}}{{.Name}}Method, in, &out, opts...); err != nil {
+		return out, err
+	}
+{{- end}}
+	return out, nil
+}
+{{else}}
+func (c *{{$.Type}}Client) {{.Name}}(ctx context.Context, in {{.In}}, opts ...{{$.Rpc}}.InvokeOption) error {
+	return c.node.InvokeNotify(ctx, {{$.Type}}{{.Name}}Method, in, opts...)
+}
+{{end}}
+{{- end}}
+`))
+
+func main() {
+	file := flag.String("file", "", "包含接口定义的go文件")
+	typeName := flag.String("type", "", "接口名")
+	version := flag.String("version", "", "服务版本, 如v1")
+	name := flag.String("name", "", "服务名, 默认为小写的接口名")
+	out := flag.String("out", "", "输出文件, 默认为{file}_rpc.go")
+	flag.Parse()
+
+	if *file == "" || *typeName == "" || *version == "" {
+		flag.Usage()
+		os.Exit(2)
+	}
+	if *name == "" {
+		*name = strings.ToLower(*typeName)
+	}
+	if *out == "" {
+		*out = strings.TrimSuffix(*file, ".go") + "_rpc.go"
+	}
+
+	if err := generate(*file, *typeName, *version, *name, *out); err != nil {
+		fmt.Fprintln(os.Stderr, "rpcgen:", err)
+		os.Exit(1)
+	}
+}
+
+func generate(file, typeName, version, name, out string) error {
+	fset := token.NewFileSet()
+	f, err := parser.ParseFile(fset, file, nil, 0)
+	if err != nil {
+		return err
+	}
+
+	iface := findInterface(f, typeName)
+	if iface == nil {
+		return fmt.Errorf("interface %s not found in %s", typeName, file)
+	}
+
+	srv := &service{Package: f.Name.Name, Type: typeName, Version: version, Name: name}
+	used := &refs{packages: map[string]bool{"context": true}, idents: map[string]bool{}}
+	for _, field := range iface.Methods.List {
+		m, err := parseMethod(field, used)
+		if err != nil {
+			return fmt.Errorf("%s: %s", fset.Position(field.Pos()), err.Error())
+		}
+		srv.Methods = append(srv.Methods, m)
+	}
+	if len(srv.Methods) == 0 {
+		return fmt.Errorf("interface %s has no method", typeName)
+	}
+	dir, err := filepath.Abs(filepath.Dir(file))
+	if err != nil {
+		return err
+	}
+	srv.Imports, srv.Common, srv.Rpc = imports(f, dir, used)
+
+	buf := &bytes.Buffer{}
+	if err = tmpl.Execute(buf, srv); err != nil {
+		return err
+	}
+	src, err := format.Source(buf.Bytes())
+	if err != nil {
+		return fmt.Errorf("format: %s\n%s", err.Error(), buf.String())
+	}
+	return ioutil.WriteFile(out, src, 0644)
+}
+
+func findInterface(f *ast.File, typeName string) *ast.InterfaceType {
+	for _, decl := range f.Decls {
+		gen, ok := decl.(*ast.GenDecl)
+		if !ok || gen.Tok != token.TYPE {
+			continue
+		}
+		for _, spec := range gen.Specs {
+			ts := spec.(*ast.TypeSpec)
+			if iface, ok := ts.Type.(*ast.InterfaceType); ok && ts.Name.Name == typeName {
+				return iface
+			}
+		}
+	}
+	return nil
+}
+
+// 方法签名必须是(ctx context.Context, in *In) (*Out, error)或(ctx context.Context, in *In) error
+func parseMethod(field *ast.Field, used *refs) (method, error) {
+	m := method{}
+	fn, ok := field.Type.(*ast.FuncType)
+	if !ok || len(field.Names) != 1 {
+		return m, fmt.Errorf("embedded interface is not supported")
+	}
+	m.Name = field.Names[0].Name
+	m.Function = strings.ToLower(m.Name)
+
+	params := expandFields(fn.Params)
+	if len(params) != 2 || types.ExprString(params[0]) != "context.Context" {
+		return m, fmt.Errorf("%s must take (context.Context, *In)", m.Name)
+	}
+	if _, ok := params[1].(*ast.StarExpr); !ok {
+		return m, fmt.Errorf("%s: parameter must be a pointer", m.Name)
+	}
+	m.In = types.ExprString(params[1])
+	usePackages(params[1], used)
+
+	results := expandFields(fn.Results)
+	if len(results) == 0 || len(results) > 2 || types.ExprString(results[len(results)-1]) != "error" {
+		return m, fmt.Errorf("%s must return (*Out, error) or error", m.Name)
+	}
+	if len(results) == 2 {
+		m.Out = types.ExprString(results[0])
+		if star, ok := results[0].(*ast.StarExpr); ok {
+			m.OutElem = types.ExprString(star.X)
+		}
+		usePackages(results[0], used)
+	}
+	return m, nil
+}
+
+// 展开a, b *T这样的多个参数
+func expandFields(fields *ast.FieldList) []ast.Expr {
+	exprs := []ast.Expr{}
+	if fields == nil {
+		return exprs
+	}
+	for _, field := range fields.List {
+		n := len(field.Names)
+		if n == 0 {
+			n = 1
+		}
+		for i := 0; i < n; i++ {
+			exprs = append(exprs, field.Type)
+		}
+	}
+	return exprs
+}
+
+// 记录类型中引用的包名和没有包名的类型名
+func usePackages(expr ast.Expr, used *refs) {
+	ast.Inspect(expr, func(node ast.Node) bool {
+		switch node := node.(type) {
+		case *ast.SelectorExpr:
+			if ident, ok := node.X.(*ast.Ident); ok {
+				used.packages[ident.Name] = true
+			}
+			return false
+		case *ast.Ident:
+			used.idents[node.Name] = true
+		}
+		return true
+	})
+}
+
+// 生成文件需要的import, 其他包沿用源文件中的import,
+// 返回import和common/rpc包在生成文件中的名字
+func imports(f *ast.File, dir string, used *refs) ([]string, string, string) {
+	// 在当前包和预定义标识符中都找不到的类型名, 来自点导入的包
+	locals := packageDecls(dir, f)
+	undeclared := map[string]bool{}
+	for ident := range used.idents {
+		if !locals[ident] && types.Universe.Lookup(ident) == nil {
+			undeclared[ident] = true
+		}
+	}
+
+	specs := map[string]bool{strconv.Quote("context"): true}
+	taken := map[string]bool{}
+	for name := range locals {
+		taken[name] = true
+	}
+	for _, spec := range f.Imports {
+		importPath, _ := strconv.Unquote(spec.Path.Value)
+		name, pkg := resolveImport(importPath, dir)
+		line := spec.Path.Value
+		if spec.Name != nil {
+			name = spec.Name.Name
+			line = name + " " + spec.Path.Value
+		}
+
+		switch {
+		case name == "_" || importPath == "context":
+		case name == ".":
+			if len(undeclared) > 0 && (pkg == nil || hasDecl(packageDecls(pkg.Dir, nil, pkg.GoFiles...), undeclared)) {
+				specs[line] = true
+			}
+		case used.packages[name]:
+			specs[line] = true
+			if importPath != commonImport && importPath != rpcImport {
+				taken[name] = true
+			}
+		}
+	}
+
+	commonName, rpcName := "common", "rpc"
+	if taken[commonName] {
+		commonName = "centercommon"
+		specs[commonName+" "+strconv.Quote(commonImport)] = true
+	} else {
+		specs[strconv.Quote(commonImport)] = true
+	}
+	if taken[rpcName] {
+		rpcName = "centerrpc"
+		specs[rpcName+" "+strconv.Quote(rpcImport)] = true
+	} else {
+		specs[strconv.Quote(rpcImport)] = true
+	}
+
+	lines := make([]string, 0, len(specs))
+	for line := range specs {
+		lines = append(lines, line)
+	}
+	sort.Slice(lines, func(i, j int) bool {
+		return strings.Trim(lines[i][strings.Index(lines[i], `"`):], `"`) <
+			strings.Trim(lines[j][strings.Index(lines[j], `"`):], `"`)
+	})
+	return lines, commonName, rpcName
+}
+
+// 包名以源码中的package为准, 找不到包时按路径推测包名
+func resolveImport(importPath, dir string) (string, *build.Package) {
+	// go list在Context.Dir中执行, 按源文件所在的模块查找包
+	ctxt := build.Default
+	ctxt.Dir = dir
+	pkg, err := ctxt.Import(importPath, dir, 0)
+	if err == nil && pkg.Name != "" {
+		return pkg.Name, pkg
+	}
+	return guessPackageName(importPath), nil
+}
+
+// 去掉路径最后的/vN, gopkg.in的.vN后缀和go-前缀, -go后缀, 再取开头合法的标识符
+func guessPackageName(importPath string) string {
+	elems := strings.Split(importPath, "/")
+	name := elems[len(elems)-1]
+	if len(elems) > 1 && isMajorVersion(name) {
+		name = elems[len(elems)-2]
+	}
+	if i := strings.LastIndex(name, ".v"); i > 0 && isMajorVersion(name[i+1:]) {
+		name = name[:i]
+	}
+	name = strings.TrimSuffix(strings.TrimPrefix(name, "go-"), "-go")
+
+	for i, ch := range name {
+		if !unicode.IsLetter(ch) && ch != '_' && (i == 0 || !unicode.IsDigit(ch)) {
+			return name[:i]
+		}
+	}
+	return name
+}
+
+func isMajorVersion(s string) bool {
+	if len(s) < 2 || s[0] != 'v' {
+		return false
+	}
+	_, err := strconv.Atoi(s[1:])
+	return err == nil
+}
+
+// 包中声明的顶层标识符, f不为nil时是当前包, 包含f和同目录下同一个包的其他文件
+func packageDecls(dir string, f *ast.File, files ...string) map[string]bool {
+	if f != nil {
+		if pkg, err := build.ImportDir(dir, 0); err == nil && pkg.Name == f.Name.Name {
+			files = pkg.GoFiles
+		}
+	}
+
+	decls := map[string]bool{}
+	addDecls := func(file *ast.File) {
+		for _, decl := range file.Decls {
+			switch decl := decl.(type) {
+			case *ast.FuncDecl:
+				if decl.Recv == nil {
+					decls[decl.Name.Name] = true
+				}
+			case *ast.GenDecl:
+				for _, spec := range decl.Specs {
+					switch spec := spec.(type) {
+					case *ast.TypeSpec:
+						decls[spec.Name.Name] = true
+					case *ast.ValueSpec:
+						for _, name := range spec.Names {
+							decls[name.Name] = true
+						}
+					}
+				}
+			}
+		}
+	}
+
+	if f != nil {
+		addDecls(f)
+	}
+	fset := token.NewFileSet()
+	for _, name := range files {
+		file, err := parser.ParseFile(fset, filepath.Join(dir, name), nil, 0)
+		if err == nil && (f == nil || file.Name.Name == f.Name.Name) {
+			addDecls(file)
+		}
+	}
+	return decls
+}
+
+func hasDecl(decls, idents map[string]bool) bool {
+	for ident := range idents {
+		if decls[ident] {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,305 @@
+package main
+
+import (
+	"go/ast"
+	"go/importer"
+	"go/parser"
+	"go/token"
+	"go/types"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"testing"
+)
+
+func TestGenerate(t *testing.T) {
+	dir, err := ioutil.TempDir("", "rpcgen")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	tests := []struct {
+		name string
+		src  string
+		want []string // 生成的代码中应该包含的内容, 为空时期望生成失败
+	}{
+		{"caller and notifier", `package pay
+
+import (
+	"context"
+	"time"
+)
+
+type ChargeReq struct{ At time.Time }
+type ChargeRes struct{}
+type RefundEvent struct{}
+
+type Payment interface {
+	Charge(ctx context.Context, in *ChargeReq) (*ChargeRes, error)
+	Refunded(ctx context.Context, in *RefundEvent) error
+}
+`, []string{
+			`PaymentVersion = "v1.2"`,
+			`PaymentName    = "payment"`,
+			`PaymentChargeFunction   = "charge"`,
+			`PaymentChargeMethod   = common.NewMethod(PaymentVersion, PaymentName, PaymentChargeFunction)`,
+			`ag.RegisterTyped(PaymentRefundedFunction, impl.Refunded)`,
+			`func (c *PaymentClient) Charge(ctx context.Context, in *ChargeReq, opts ...rpc.InvokeOption) (*ChargeRes, error) {`,
+			`c.node.Invoke(ctx, PaymentChargeMethod, in, out, opts...)`,
+			`c.node.InvokeNotify(ctx, PaymentRefundedMethod, in, opts...)`,
+			`"gitlab.forceup.in/zengliang/rpc2-center/common"`,
+		}},
+		{"missing context", `package pay
+
+type Payment interface {
+	Charge(in *int) (*int, error)
+}
+`, nil},
+		{"non pointer parameter", `package pay
+
+import "context"
+
+type Payment interface {
+	Charge(ctx context.Context, in int) (*int, error)
+}
+`, nil},
+		{"missing error", `package pay
+
+import "context"
+
+type Payment interface {
+	Charge(ctx context.Context, in *int) *int
+}
+`, nil},
+		{"no method", `package pay
+
+type Payment interface{}
+`, nil},
+	}
+
+	for i, tt := range tests {
+		file := filepath.Join(dir, strings.Replace(tt.name, " ", "_", -1)+".go")
+		out := filepath.Join(dir, strings.Replace(tt.name, " ", "_", -1)+"_rpc.go")
+		if err := ioutil.WriteFile(file, []byte(tt.src), 0644); err != nil {
+			t.Fatal(err)
+		}
+
+		err := generate(file, "Payment", "v1.2", "payment", out)
+		if tt.want == nil {
+			if err == nil {
+				t.Errorf("case %d %s: expected error", i, tt.name)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("case %d %s: %s", i, tt.name, err.Error())
+			continue
+		}
+
+		b, err := ioutil.ReadFile(out)
+		if err != nil {
+			t.Fatal(err)
+		}
+		for _, s := range tt.want {
+			if !strings.Contains(string(b), s) {
+				t.Errorf("case %d %s: missing %q in:\n%s", i, tt.name, s, b)
+			}
+		}
+	}
+}
+
+// 按源码检查导入的包, sources中没有的包从GOROOT中加载
+type stubImporter struct {
+	fset    *token.FileSet
+	sources map[string]string
+	pkgs    map[string]*types.Package
+	std     types.Importer
+}
+
+func (imp *stubImporter) Import(importPath string) (*types.Package, error) {
+	if pkg, ok := imp.pkgs[importPath]; ok {
+		return pkg, nil
+	}
+	src, ok := imp.sources[importPath]
+	if !ok {
+		return imp.std.Import(importPath)
+	}
+
+	file, err := parser.ParseFile(imp.fset, importPath+".go", src, 0)
+	if err != nil {
+		return nil, err
+	}
+	conf := types.Config{Importer: imp}
+	pkg, err := conf.Check(importPath, imp.fset, []*ast.File{file}, nil)
+	if err != nil {
+		return nil, err
+	}
+	imp.pkgs[importPath] = pkg
+	return pkg, nil
+}
+
+// 生成的代码与源文件一起可以通过类型检查
+func TestGenerateTypeCheck(t *testing.T) {
+	dir, err := ioutil.TempDir("", "rpcgen")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	sources := map[string]string{
+		commonImport: `package common
+
+type Method struct{ Version, Name, Function string }
+
+func NewMethod(version, name, function string) Method { return Method{version, name, function} }
+`,
+		rpcImport: `package rpc
+
+import (
+	"context"
+	"gitlab.forceup.in/zengliang/rpc2-center/common"
+)
+
+type ApiInfoGroup struct{}
+
+func (ag *ApiInfoGroup) RegisterTyped(name string, fn interface{}) error { return nil }
+
+type Node struct{}
+
+type InvokeOption func()
+
+func (n *Node) Invoke(ctx context.Context, method common.Method, in interface{}, out interface{}, opts ...InvokeOption) error {
+	return nil
+}
+
+func (n *Node) InvokeNotify(ctx context.Context, method common.Method, in interface{}, opts ...InvokeOption) error {
+	return nil
+}
+`,
+		"example.com/money/v2":  "package money\n\ntype Amount struct{}\n",
+		"example.com/go-money":  "package money\n\ntype Amount struct{}\n",
+		"gopkg.in/yaml.v2":      "package yaml\n\ntype Node struct{}\n",
+		"example.com/driver":    "package driver\n",
+		"example.com/shared":    "package shared\n\ntype Order struct{}\n",
+		"example.com/shop/rpc":  "package rpc\n\ntype Order struct{}\n",
+		"example.com/pay/types": "package common\n\ntype Order struct{}\n",
+	}
+
+	tests := []struct {
+		name  string
+		src   string
+		files map[string]string // 同一个模块中的其他文件
+	}{
+		{"major version", `package pay
+
+import (
+	"context"
+	"example.com/money/v2"
+)
+
+type Payment interface {
+	Charge(ctx context.Context, in *money.Amount) (*money.Amount, error)
+}
+`, nil},
+		{"go prefix and gopkg.in", `package pay
+
+import (
+	"context"
+	"example.com/go-money"
+	"gopkg.in/yaml.v2"
+)
+
+type Payment interface {
+	Charge(ctx context.Context, in *money.Amount) (*yaml.Node, error)
+}
+`, nil},
+		{"blank and dot import", `package pay
+
+import (
+	"context"
+	_ "example.com/driver"
+	. "example.com/shared"
+)
+
+type Payment interface {
+	Charge(ctx context.Context, in *Order) (*Order, error)
+}
+`, nil},
+		{"unused dot import", `package pay
+
+import (
+	"context"
+	. "example.com/shared"
+)
+
+var _ Order
+
+type Refund struct{}
+
+type Payment interface {
+	Refunded(ctx context.Context, in *Refund) error
+}
+`, nil},
+		{"package named rpc and common", `package pay
+
+import (
+	"context"
+	"example.com/pay/types"
+	"example.com/shop/rpc"
+)
+
+type Payment interface {
+	Charge(ctx context.Context, in *rpc.Order) (*common.Order, error)
+}
+`, map[string]string{
+			"go.mod":         "module example.com/pay\n",
+			"types/types.go": "package common\n\ntype Order struct{}\n",
+		}},
+	}
+
+	for i, tt := range tests {
+		caseDir := filepath.Join(dir, strconv.Itoa(i))
+		if err := os.Mkdir(caseDir, 0755); err != nil {
+			t.Fatal(err)
+		}
+		for name, src := range tt.files {
+			os.MkdirAll(filepath.Dir(filepath.Join(caseDir, name)), 0755)
+			if err := ioutil.WriteFile(filepath.Join(caseDir, name), []byte(src), 0644); err != nil {
+				t.Fatal(err)
+			}
+		}
+		file := filepath.Join(caseDir, "pay.go")
+		out := filepath.Join(caseDir, "pay_rpc.go")
+		if err := ioutil.WriteFile(file, []byte(tt.src), 0644); err != nil {
+			t.Fatal(err)
+		}
+		if err := generate(file, "Payment", "v1", "payment", out); err != nil {
+			t.Errorf("case %d %s: %s", i, tt.name, err.Error())
+			continue
+		}
+
+		fset := token.NewFileSet()
+		files := []*ast.File{}
+		for _, name := range []string{file, out} {
+			f, err := parser.ParseFile(fset, name, nil, 0)
+			if err != nil {
+				t.Fatal(err)
+			}
+			files = append(files, f)
+		}
+
+		conf := types.Config{Importer: &stubImporter{
+			fset:    fset,
+			sources: sources,
+			pkgs:    map[string]*types.Package{},
+			std:     importer.ForCompiler(fset, "source", nil),
+		}}
+		if _, err := conf.Check("example.com/pay", fset, files, nil); err != nil {
+			b, _ := ioutil.ReadFile(out)
+			t.Errorf("case %d %s: %s in:\n%s", i, tt.name, err.Error(), b)
+		}
+	}
+}
@@ -1,7 +1,9 @@
 package common
 
 import (
+	"gitlab.forceup.in/zengliang/rpc2-center/tools"
 	"strings"
+	"time"
 )
 
 type (
@@ -12,20 +14,145 @@ type (
 		Tag     string `json:"tag"`
 	}
 
+	// rpc2连接的TLS配置
+	TLSConfig struct {
+		Enable            bool   `json:"enable"`
+		CertFile          string `json:"cert_file"`           // 证书, center必填, node配置后作为客户端证书
+		KeyFile           string `json:"key_file"`            // 证书私钥
+		CAFile            string `json:"ca_file"`             // 校验对端证书的CA, 为空时使用系统CA
+		RequireClientCert bool   `json:"require_client_cert"` // center要求节点提供由CA签发的证书
+		ServerName        string `json:"server_name"`         // node校验center证书时使用的名称, 默认为rpc_addr的host
+	}
+
+	// 节点注册鉴权, Mode为空时不校验
+	RegisterAuthConfig struct {
+		Mode    string            `json:"mode"`     // static或hmac
+		Secrets map[string]string `json:"secrets"`  // key为version.name, "*"为所有服务共用
+		MaxSkew int               `json:"max_skew"` // hmac允许的时间误差秒数, 默认300
+	}
+
+	// 令牌桶限流, Rate小于等于0时不限流
+	RateLimit struct {
+		Rate  float64 `json:"rate"`  // 每秒请求数
+		Burst int     `json:"burst"` // 桶容量, 默认与rate相同
+	}
+
+	// http网关的api key
+	ApiKey struct {
+		Key  string `json:"key"`
+		Name string `json:"name"` // 调用方名称, 在acl中使用apikey:<name>
+		RateLimit
+	}
+
+	// http网关鉴权和限流
+	HttpAuthConfig struct {
+		Enable   bool                 `json:"enable"`   // 启用后/call, /notify和/discover必须携带有效的api key
+		Services map[string]RateLimit `json:"services"` // 按目标服务(version.name)限流, "*"为每个服务的默认值
+	}
+
+	// 调用权限规则
+	AclRule struct {
+		From  []string `json:"from"`  // 调用方: 服务key(version.name), apikey:<name>, anonymous或*
+		Allow []string `json:"allow"` // 允许调用的version.name.function, 支持*通配
+	}
+
+	// 调用权限, 不启用时允许所有调用
+	AclConfig struct {
+		Enable bool      `json:"enable"`
+		Rules  []AclRule `json:"rules"`
+	}
+
+	// 调用失败重试策略
+	RetryPolicy struct {
+		MaxAttempts    int       `json:"max_attempts"`    // 最多尝试次数(含第一次), 小于等于1时不重试
+		RetryCodes     []ErrCode `json:"retry_codes"`     // 可重试的错误码, 为空时只重试ErrCallFailed
+		IdempotentOnly bool      `json:"idempotent_only"` // 只重试节点声明为幂等的caller
+	}
+
+	// 负载均衡配置
+	BalancerConfig struct {
+		Strategy string `json:"strategy"` // round_robin(默认), random, weighted, least_inflight, hash
+		HashKey  string `json:"hash_key"` // hash策略从Request.Context中取值的key
+	}
+
+	// 节点熔断配置, FailureThreshold小于等于0时不启用
+	BreakerConfig struct {
+		FailureThreshold int `json:"failure_threshold"`   // 连续失败多少次后熔断
+		OpenTimeout      int `json:"open_timeout"`        // 熔断持续秒数, 之后进入半开状态试探
+		HalfOpenMaxCalls int `json:"half_open_max_calls"` // 半开状态同时允许的试探调用数, 默认1
+	}
+
+	// 流量规则的目标, 为空的字段不修改请求
+	TrafficTarget struct {
+		Version string `json:"version"`
+		Tag     string `json:"tag"`
+		Weight  int    `json:"weight"` // 按权重比例分配
+	}
+
+	// 流量规则, 调用service(version.name)且没有指定tag的请求按权重分配到targets,
+	// 设置了match_key时只处理上下文中该值在match_values中的请求
+	TrafficRule struct {
+		Name        string          `json:"name"`
+		Service     string          `json:"service"`
+		MatchKey    string          `json:"match_key"`
+		MatchValues []string        `json:"match_values"`
+		Targets     []TrafficTarget `json:"targets"`
+	}
+
 	// 服务中心
 	ConfigCenter struct {
 		Service
-		HttpPort  string   `json:"http_port"`
-		RpcPort   string   `json:"rpc_port"`
-		KeepAlive int      `json:"keep_alive"`
-		Env       []string `json:"env"`
+		HttpPort  string        `json:"http_port"`
+		RpcPort   string        `json:"rpc_port"`
+		KeepAlive int           `json:"keep_alive"`
+		Env       []string      `json:"env"`
+		TLS       TLSConfig     `json:"tls"`
+		Retry     RetryPolicy   `json:"retry"`
+		Breaker   BreakerConfig `json:"breaker"`
+
+		RegisterAuth RegisterAuthConfig `json:"register_auth"`
+		ApiKeys      []ApiKey           `json:"api_keys"`
+		HttpAuth     HttpAuthConfig     `json:"http_auth"`
+		Acl          AclConfig          `json:"acl"`
+
+		// 各服务的负载均衡策略, key为version.name, "*"为默认策略
+		Balancer map[string]BalancerConfig `json:"balancer"`
+
+		// 集群中其他center的rpc地址, 互相同步注册的节点并转发请求
+		Peers      []string `json:"peers"`
+		PeerSecret string   `json:"peer_secret"` // center之间hmac认证的密钥, 为空时不接受其他center加入
+
+		VersionPolicy string `json:"version_policy"` // exact或compatible, 默认exact
+
+		AdminToken string `json:"admin_token"` // 管理接口/admin/的Bearer token, 为空时不开启
+
+		// 流量规则, 按顺序匹配第一条, 在版本解析之前生效
+		Traffic []TrafficRule `json:"traffic"`
+	}
+
+	// 节点重连center的退避策略, 每次失败后等待时间乘以multiplier, 注册成功后重置
+	BackoffConfig struct {
+		Initial    int     `json:"initial"`    // 首次等待的毫秒数, 默认1000
+		Max        int     `json:"max"`        // 最大等待的毫秒数, 默认30000
+		Multiplier float64 `json:"multiplier"` // 默认2
+		Jitter     float64 `json:"jitter"`     // 随机抖动比例(0-1), 0为不抖动
 	}
 
 	// 服务节点
 	ConfigNode struct {
 		Service
-		RpcAddr string   `json:"rpc_addr"`
-		Env     []string `json:"env"`
+		RpcAddr    string    `json:"rpc_addr"`
+		RpcAddrs   []string  `json:"rpc_addrs"`   // 多个center地址, 与rpc_addr合并
+		CenterMode string    `json:"center_mode"` // failover或active, 默认failover
+		Env        []string  `json:"env"`
+		TLS        TLSConfig `json:"tls"`
+
+		AuthMode string `json:"auth_mode"` // 注册鉴权方式, static或hmac, 与center一致
+		Secret   string `json:"secret"`    // 注册鉴权的secret
+
+		DrainTimeout int `json:"drain_timeout"` // 停止时等待正在处理的请求结束的秒数, 默认10
+
+		Backoff BackoffConfig `json:"backoff"`
 	}
 )
 
@@ -38,3 +165,61 @@ func (s Service) GetKey() string {
 func (s Service) GetInstance() string {
 	return strings.ToLower(s.Version + "." + s.Name + "." + s.Tag)
 }
+
+// 获取服务注册鉴权的secret, 没有单独配置时使用"*"
+func (a RegisterAuthConfig) GetSecret(srvKey string) (string, bool) {
+	secret, ok := a.Secrets[srvKey]
+	if !ok {
+		secret, ok = a.Secrets["*"]
+	}
+	return secret, ok && secret != ""
+}
+
+// 按配置创建退避计时, 未配置的项使用默认值
+func (b BackoffConfig) NewBackoff() *tools.Backoff {
+	initial, max, multiplier, jitter := b.Initial, b.Max, b.Multiplier, b.Jitter
+	if initial <= 0 {
+		initial = 1000
+	}
+	if max <= 0 {
+		max = 30000
+	}
+	if multiplier < 1 {
+		multiplier = 2
+	}
+	if jitter < 0 {
+		jitter = 0
+	} else if jitter > 1 {
+		jitter = 1
+	}
+
+	return tools.NewBackoff(time.Duration(initial)*time.Millisecond,
+		time.Duration(max)*time.Millisecond, multiplier, jitter)
+}
+
+// 所有center地址, rpc_addr在前, 去掉重复
+func (c ConfigNode) GetRpcAddrs() []string {
+	addrs := []string{}
+	exists := make(map[string]bool)
+	for _, addr := range append([]string{c.RpcAddr}, c.RpcAddrs...) {
+		if addr == "" || exists[addr] {
+			continue
+		}
+		exists[addr] = true
+		addrs = append(addrs, addr)
+	}
+	return addrs
+}
+
+// 错误码是否可以重试
+func (p RetryPolicy) IsRetryCode(code ErrCode) bool {
+	if len(p.RetryCodes) == 0 {
+		return code == ErrCallFailed
+	}
+	for _, c := range p.RetryCodes {
+		if c == code {
+			return true
+		}
+	}
+	return false
+}
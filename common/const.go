@@ -9,17 +9,95 @@ const (
 	MethodCenterUnRegister = "Center.UnRegister"
 	MethodCenterCall       = "Center.Call"
 	MethodCenterNotify     = "Center.Notify"
+	MethodCenterDrain      = "Center.Drain"
+	MethodCenterWatch      = "Center.Watch"
+	MethodCenterDiscover   = "Center.Discover"
+
+	MethodCenterPeerJoin   = "Center.PeerJoin"
+	MethodCenterPeerEvent  = "Center.PeerEvent"
+	MethodCenterPeerCall   = "Center.PeerCall"
+	MethodCenterPeerNotify = "Center.PeerNotify"
 
 	MethodNodeCall      = "Node.Call"
 	MethodNodeNotify    = "Node.Notify"
 	MethodNodeKeepAlive = "Node.KeepAlive"
+	MethodNodeClosing   = "Node.CenterClosing"
+	MethodNodeWatch     = "Node.WatchEvent"
+)
+
+// 负载均衡策略
+const (
+	BalanceRoundRobin    = "round_robin"
+	BalanceRandom        = "random"
+	BalanceWeighted      = "weighted"
+	BalanceLeastInFlight = "least_inflight"
+	BalanceHash          = "hash"
+)
+
+// Register.Meta中的保留key
+const (
+	MetaKeyWeight = "weight" // weighted策略使用的节点权重, 默认1
+)
+
+// 节点注册鉴权方式
+const (
+	RegisterAuthStatic = "static" // token为服务的secret
+	RegisterAuthHmac   = "hmac"   // token为secret对服务key和时间戳的签名
+)
+
+// 请求版本的解析方式
+const (
+	VersionPolicyExact      = "exact"      // 只调用版本完全相同的服务
+	VersionPolicyCompatible = "compatible" // 没有完全相同的版本时, 调用兼容的最高版本, 支持v1, v1.2, ^1.2, ~1.2
+)
+
+// 注册事件类型
+const (
+	EventRegister   = "register"
+	EventUnRegister = "unregister"
 )
 
+// 节点连接多个center的方式
+const (
+	CenterModeFailover = "failover" // 只连接第一个可用的center, 断开后依次尝试下一个
+	CenterModeActive   = "active"   // 同时连接所有center, 发出的请求轮流使用
+)
+
+// 请求上下文(Request.Context)中的保留key
+const (
+	ContextKeyDeadline    = "deadline"    // 请求截止时间, unix纳秒
+	ContextKeyTraceParent = "traceparent" // W3C traceparent
+	ContextKeyTraceState  = "tracestate"  // W3C tracestate
+	ContextKeyCaller      = "caller"      // 调用方身份, 由center设置
+	ContextKeyHops        = "hops"        // 请求经过的center id, 逗号分隔, 防止在center之间循环转发
+
+	ContextKeyServedVersion = "served_version" // 应答上下文(Response.Context)中实际处理请求的服务版本
+)
+
+// 调用方身份
+const (
+	CallerAnonymous    = "anonymous" // 没有api key的http请求或未注册的连接
+	CallerApiKeyPrefix = "apikey:"   // http请求为apikey:<name>, rpc2请求为调用方服务的version.name
+)
+
+// http请求携带api key的header和query参数
+const (
+	HeaderApiKey = "X-Api-Key"
+	QueryApiKey  = "api_key"
+)
+
+// http应答中实际处理请求的服务版本
+const HeaderServedVersion = "X-Served-Version"
+
+// http header中以此为前缀的值放入请求上下文, 如X-Rpc-Ctx-Merchant-Id对应merchant_id
+const HeaderContextPrefix = "X-Rpc-Ctx-"
+
 type ConnectStatus int
 
 var statusStrings = map[ConnectStatus]string{
 	ConnectStatusConnected:    "connected",
 	ConnectStatusDisConnected: "disconnected",
+	ConnectStatusReconnecting: "reconnecting",
 }
 
 func (cs ConnectStatus) String() string {
@@ -37,4 +115,5 @@ func (cs ConnectStatus) Int() int {
 const (
 	ConnectStatusConnected    = ConnectStatus(1)
 	ConnectStatusDisConnected = ConnectStatus(2)
+	ConnectStatusReconnecting = ConnectStatus(3) // 等待下一次重连
 )
@@ -1,10 +1,16 @@
 package common
 
 import (
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
 	"encoding/base64"
+	"encoding/hex"
 	"encoding/json"
 	"fmt"
+	"strconv"
 	"strings"
+	"time"
 )
 
 // 对外输入输出
@@ -21,11 +27,64 @@ type (
 	Context  map[string]interface{}
 	Register struct {
 		Service
-		StartAt      string            `json:"start_at"`
-		Meta         map[string]string `json:"meta"`
-		Env          map[string]string `json:"env"`
-		CallerList   []string          `json:"caller_list"`
-		NotifierList []string          `json:"notifier_list"`
+		Id             string            `json:"id,omitempty"` // center分配的节点id
+		StartAt        string            `json:"start_at"`
+		Meta           map[string]string `json:"meta"`
+		Env            map[string]string `json:"env"`
+		CallerList     []string          `json:"caller_list"`
+		NotifierList   []string          `json:"notifier_list"`
+		IdempotentList []string          `json:"idempotent_list"`
+		Timestamp      int64             `json:"timestamp,omitempty"` // 注册鉴权的时间戳(unix秒)
+		Token          string            `json:"token,omitempty"`     // 注册鉴权的token, center校验后清空
+	}
+
+	// 节点运行状态
+	NodeStatus struct {
+		Register
+		Breaker     string `json:"breaker"`
+		InFlight    int64  `json:"in_flight"`
+		Draining    bool   `json:"draining"`
+		Cordoned    bool   `json:"cordoned"`
+		Calls       int64  `json:"calls"`        // 已完成的调用次数
+		Failures    int64  `json:"failures"`     // 失败的调用次数
+		Uptime      int64  `json:"uptime"`       // 节点启动后的秒数
+		KeepAliveAt int64  `json:"keepalive_at"` // 最近一次心跳成功的时间(unix秒), 不检查心跳的节点为0
+	}
+
+	// 节点注册/注销事件, 在center之间同步, 并推送给订阅的节点
+	RegistryEvent struct {
+		Type string   `json:"type"`
+		Node Register `json:"node"`
+	}
+
+	// 加入集群时交换的数据, Center为center自己的注册信息, Nodes为注册在该center上的节点
+	PeerSnapshot struct {
+		Center Register   `json:"center"`
+		Nodes  []Register `json:"nodes"`
+	}
+
+	// 流量规则和命中次数
+	TrafficRuleStatus struct {
+		TrafficRule
+		Hits       int64   `json:"hits"`
+		TargetHits []int64 `json:"target_hits"`
+	}
+
+	// http服务发现返回的节点信息, 不包括环境变量和函数列表
+	DiscoverInstance struct {
+		Service
+		Id      string            `json:"id"`
+		StartAt string            `json:"start_at"`
+		Meta    map[string]string `json:"meta"`
+	}
+
+	// 服务发现的过滤条件, 为空的条件不过滤
+	DiscoverFilter struct {
+		Version string            `json:"version"`
+		Name    string            `json:"name"`
+		Tag     string            `json:"tag"`
+		Meta    map[string]string `json:"meta"`
+		Env     map[string]string `json:"env"`
 	}
 
 	Method struct {
@@ -49,6 +108,8 @@ type (
 		Context Context     `json:"context"`
 		Method  Method      `json:"method"`
 		Data    UserRequest `json:"data"`
+
+		ctx context.Context
 	}
 
 	Response struct {
@@ -89,6 +150,95 @@ func (self *Response) SetOkResult(i interface{}) error {
 	return self.Data.SetResult(i)
 }
 
+// 按鉴权方式设置注册token
+func (reg *Register) Sign(mode, secret string) {
+	switch mode {
+	case RegisterAuthStatic:
+		reg.Token = secret
+	case RegisterAuthHmac:
+		reg.Timestamp = time.Now().Unix()
+		reg.Token = SignRegister(secret, reg.GetKey(), reg.Timestamp)
+	}
+}
+
+// 注册token: hex(hmac-sha256(secret, "version.name|timestamp"))
+func SignRegister(secret, srvKey string, timestamp int64) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write([]byte(srvKey + "|" + strconv.FormatInt(timestamp, 10)))
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+// 节点是否满足过滤条件, version/name/tag不区分大小写
+func (f DiscoverFilter) Match(reg *Register) bool {
+	if f.Version != "" && !strings.EqualFold(f.Version, reg.Version) ||
+		f.Name != "" && !strings.EqualFold(f.Name, reg.Name) ||
+		f.Tag != "" && !strings.EqualFold(f.Tag, reg.Tag) {
+		return false
+	}
+	for k, v := range f.Meta {
+		if value, ok := reg.Meta[k]; !ok || value != v {
+			return false
+		}
+	}
+	for k, v := range f.Env {
+		if value, ok := reg.Env[k]; !ok || value != v {
+			return false
+		}
+	}
+	return true
+}
+
+// 设置请求截止时间, 已有更早的截止时间时保持不变
+func (req *Request) SetDeadline(t time.Time) {
+	if d, ok := req.Deadline(); ok && d.Before(t) {
+		return
+	}
+	if req.Context == nil {
+		req.Context = make(Context)
+	}
+	req.Context[ContextKeyDeadline] = t.UnixNano()
+}
+
+// 获取请求截止时间
+func (req *Request) Deadline() (time.Time, bool) {
+	switch d := req.Context[ContextKeyDeadline].(type) {
+	case int64:
+		return time.Unix(0, d), true
+	case float64:
+		return time.Unix(0, int64(d)), true
+	}
+	return time.Time{}, false
+}
+
+// 根据请求截止时间派生context
+func (req *Request) WithDeadline(parent context.Context) (context.Context, context.CancelFunc) {
+	if d, ok := req.Deadline(); ok {
+		return context.WithDeadline(parent, d)
+	}
+	return context.WithCancel(parent)
+}
+
+// 处理请求时的context, 超时或调用方放弃时Done
+func (req *Request) Ctx() context.Context {
+	if req.ctx != nil {
+		return req.ctx
+	}
+	return context.Background()
+}
+
+func (req *Request) SetCtx(ctx context.Context) {
+	req.ctx = ctx
+}
+
+func NewMethod(version, name, function string) Method {
+	return Method{Service: Service{Version: version, Name: name}, Function: function}
+}
+
+// 获取方法唯一key(version.name.function)
+func (method Method) GetFunctionKey() string {
+	return method.GetKey() + "." + strings.ToLower(method.Function)
+}
+
 // 从path解析方法
 func (method *Method) FromPath(path string) {
 	path = strings.Trim(path, "/")
@@ -0,0 +1,78 @@
+package common
+
+import (
+	"testing"
+	"time"
+)
+
+func TestSignRegister(t *testing.T) {
+	tests := []struct {
+		secret    string
+		srvKey    string
+		timestamp int64
+		want      string
+	}{
+		{"secret", "v1.pay", 1600000000, "3a955d1400eb2de562e5b86f8acf348187d6ef752e3316713a8fa9f3bb5e7025"},
+	}
+
+	for _, tt := range tests {
+		got := SignRegister(tt.secret, tt.srvKey, tt.timestamp)
+		if got != tt.want {
+			t.Errorf("SignRegister() = %s, want %s", got, tt.want)
+		}
+		if got == SignRegister(tt.secret+"x", tt.srvKey, tt.timestamp) ||
+			got == SignRegister(tt.secret, tt.srvKey+"x", tt.timestamp) ||
+			got == SignRegister(tt.secret, tt.srvKey, tt.timestamp+1) {
+			t.Errorf("SignRegister() should change with secret, key and timestamp")
+		}
+	}
+}
+
+func TestRegisterSign(t *testing.T) {
+	tests := []struct {
+		mode      string
+		token     func(reg *Register) string
+		timestamp bool
+	}{
+		{"", func(reg *Register) string { return "" }, false},
+		{RegisterAuthStatic, func(reg *Register) string { return "s" }, false},
+		{RegisterAuthHmac, func(reg *Register) string { return SignRegister("s", "v1.pay", reg.Timestamp) }, true},
+	}
+
+	for _, tt := range tests {
+		reg := &Register{Service: Service{Version: "V1", Name: "Pay"}}
+		reg.Sign(tt.mode, "s")
+
+		if reg.Token != tt.token(reg) {
+			t.Errorf("mode %q: token = %q, want %q", tt.mode, reg.Token, tt.token(reg))
+		}
+		if skew := time.Now().Unix() - reg.Timestamp; tt.timestamp != (reg.Timestamp != 0) || tt.timestamp && skew > 1 {
+			t.Errorf("mode %q: unexpected timestamp %d", tt.mode, reg.Timestamp)
+		}
+	}
+}
+
+func TestResponseError(t *testing.T) {
+	tests := []struct {
+		err  ErrCode
+		msg  string
+		want string
+	}{
+		{ErrOk, "", ""},
+		{ErrCallTimeout, "", "err_code:1006, message:call timeout"},
+		{ErrCallFailed, "node down", "err_code:1002, message:node down"},
+	}
+
+	for _, tt := range tests {
+		res := &Response{}
+		res.Data.Err, res.Data.ErrMsg = tt.err, tt.msg
+
+		got := ""
+		if err := res.Error(); err != nil {
+			got = err.Error()
+		}
+		if got != tt.want {
+			t.Errorf("Error() = %q, want %q", got, tt.want)
+		}
+	}
+}
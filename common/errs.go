@@ -1,6 +1,8 @@
 package common
 
 import (
+	"context"
+	"fmt"
 	"sync"
 )
 
@@ -15,6 +17,14 @@ const (
 	ErrNotFindCaller   = ErrCode(1003) // 没有找到方法
 	ErrNotFindNotifier = ErrCode(1004) // 没有找到通知
 	ErrDataCorrupted   = ErrCode(1005) // 数据损坏
+	ErrCallTimeout     = ErrCode(1006) // 调用超时
+	ErrRegisterDenied  = ErrCode(1007) // 注册鉴权失败
+	ErrNoPermission    = ErrCode(1008) // 没有调用权限
+	ErrUnauthorized    = ErrCode(1009) // 没有有效的api key
+	ErrRateLimited     = ErrCode(1010) // 请求过于频繁
+	ErrCenterClosing   = ErrCode(1011) // center正在关闭
+	ErrInvalidSelector = ErrCode(1012) // tag选择器格式错误
+	ErrCallCanceled    = ErrCode(1013) // 调用被取消
 )
 
 var err_msgs = map[ErrCode]string{
@@ -24,7 +34,15 @@ var err_msgs = map[ErrCode]string{
 	ErrCallFailed:      "call method failed",
 	ErrNotFindCaller:   "method not found",
 	ErrNotFindNotifier: "notifier not found",
-	ErrDataCorrupted:   "invalid data"}
+	ErrDataCorrupted:   "invalid data",
+	ErrCallTimeout:     "call timeout",
+	ErrRegisterDenied:  "register denied",
+	ErrNoPermission:    "permission denied",
+	ErrUnauthorized:    "unauthorized",
+	ErrRateLimited:     "rate limited",
+	ErrCenterClosing:   "center is closing",
+	ErrInvalidSelector: "invalid selector",
+	ErrCallCanceled:    "call canceled"}
 
 var mutx sync.Mutex
 
@@ -45,3 +63,29 @@ func (self ErrCode) String() string {
 	}
 	return msg
 }
+
+// ctx结束对应的错误码, 超过截止时间为ErrCallTimeout, 其他为ErrCallCanceled
+func ContextErrCode(err error) ErrCode {
+	if err == context.DeadlineExceeded {
+		return ErrCallTimeout
+	}
+	return ErrCallCanceled
+}
+
+// 带错误码的error
+type CodeError struct {
+	Code ErrCode
+	Msg  string
+}
+
+func NewCodeError(code ErrCode, err_fmt string, args ...interface{}) *CodeError {
+	return &CodeError{Code: code, Msg: fmt.Sprintf(err_fmt, args...)}
+}
+
+func (e *CodeError) Error() string {
+	message := e.Msg
+	if message == "" {
+		message = e.Code.String()
+	}
+	return fmt.Sprintf("err_code:%d, message:%s", e.Code, message)
+}
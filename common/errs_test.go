@@ -0,0 +1,25 @@
+package common
+
+import (
+	"context"
+	"testing"
+)
+
+func TestContextErrCode(t *testing.T) {
+	canceled, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	tests := []struct {
+		err  error
+		want ErrCode
+	}{
+		{context.DeadlineExceeded, ErrCallTimeout},
+		{canceled.Err(), ErrCallCanceled},
+	}
+
+	for _, tt := range tests {
+		if got := ContextErrCode(tt.err); got != tt.want {
+			t.Errorf("ContextErrCode(%v) = %d, want %d", tt.err, got, tt.want)
+		}
+	}
+}
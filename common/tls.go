@@ -0,0 +1,80 @@
+package common
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"io/ioutil"
+	"net"
+)
+
+// center监听使用的tls配置
+func (t TLSConfig) ServerConfig() (*tls.Config, error) {
+	cert, err := tls.LoadX509KeyPair(t.CertFile, t.KeyFile)
+	if err != nil {
+		return nil, err
+	}
+
+	conf := &tls.Config{
+		Certificates: []tls.Certificate{cert},
+		MinVersion:   tls.VersionTLS12,
+	}
+
+	if t.RequireClientCert {
+		conf.ClientAuth = tls.RequireAndVerifyClientCert
+		if conf.ClientCAs, err = loadCertPool(t.CAFile); err != nil {
+			return nil, err
+		}
+	}
+
+	return conf, nil
+}
+
+// node连接center使用的tls配置
+func (t TLSConfig) ClientConfig(addr string) (*tls.Config, error) {
+	conf := &tls.Config{
+		ServerName: t.ServerName,
+		MinVersion: tls.VersionTLS12,
+	}
+
+	if conf.ServerName == "" {
+		host, _, err := net.SplitHostPort(addr)
+		if err != nil {
+			return nil, err
+		}
+		conf.ServerName = host
+	}
+
+	if t.CertFile != "" {
+		cert, err := tls.LoadX509KeyPair(t.CertFile, t.KeyFile)
+		if err != nil {
+			return nil, err
+		}
+		conf.Certificates = []tls.Certificate{cert}
+	}
+
+	var err error
+	if conf.RootCAs, err = loadCertPool(t.CAFile); err != nil {
+		return nil, err
+	}
+
+	return conf, nil
+}
+
+// 加载CA证书, file为空时返回nil使用系统CA
+func loadCertPool(file string) (*x509.CertPool, error) {
+	if file == "" {
+		return nil, nil
+	}
+
+	pem, err := ioutil.ReadFile(file)
+	if err != nil {
+		return nil, err
+	}
+
+	pool := x509.NewCertPool()
+	if !pool.AppendCertsFromPEM(pem) {
+		return nil, fmt.Errorf("no certificate found in %s", file)
+	}
+	return pool, nil
+}
@@ -0,0 +1,150 @@
+package common
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"io/ioutil"
+	"math/big"
+	"net"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+// 生成证书和私钥文件, parent为nil时生成自签名的CA
+func writeTestCert(t *testing.T, dir, name string, tmpl *x509.Certificate,
+	parent *x509.Certificate, parentKey *ecdsa.PrivateKey) (*x509.Certificate, *ecdsa.PrivateKey) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tmpl.Subject = pkix.Name{CommonName: name}
+	tmpl.NotBefore = time.Now().Add(-time.Hour)
+	tmpl.NotAfter = time.Now().Add(time.Hour)
+	if parent == nil {
+		tmpl.IsCA = true
+		tmpl.BasicConstraintsValid = true
+		tmpl.KeyUsage = x509.KeyUsageCertSign
+		parent, parentKey = tmpl, key
+	}
+
+	der, err := x509.CreateCertificate(rand.Reader, tmpl, parent, &key.PublicKey, parentKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	keyDer, err := x509.MarshalECPrivateKey(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	certPem := pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der})
+	keyPem := pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDer})
+	if err = ioutil.WriteFile(filepath.Join(dir, name+".pem"), certPem, 0600); err != nil {
+		t.Fatal(err)
+	}
+	if err = ioutil.WriteFile(filepath.Join(dir, name+".key"), keyPem, 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	cert, err := x509.ParseCertificate(der)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return cert, key
+}
+
+// 在本机连接上完成握手, 双方都成功时返回nil
+func testHandshake(t *testing.T, server TLSConfig, client TLSConfig) error {
+	serverConf, err := server.ServerConfig()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer l.Close()
+
+	serverErr := make(chan error, 1)
+	go func() {
+		conn, err := l.Accept()
+		if err != nil {
+			serverErr <- err
+			return
+		}
+		defer conn.Close()
+		serverErr <- tls.Server(conn, serverConf).Handshake()
+	}()
+
+	clientConf, err := client.ClientConfig(l.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	conn, err := tls.Dial("tcp", l.Addr().String(), clientConf)
+	if err == nil {
+		defer conn.Close()
+	}
+	if e := <-serverErr; e != nil {
+		return e
+	}
+	return err
+}
+
+func TestTLSHandshake(t *testing.T) {
+	dir, err := ioutil.TempDir("", "tls")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	ca, caKey := writeTestCert(t, dir, "ca", &x509.Certificate{SerialNumber: big.NewInt(1)}, nil, nil)
+	writeTestCert(t, dir, "other-ca", &x509.Certificate{SerialNumber: big.NewInt(2)}, nil, nil)
+	writeTestCert(t, dir, "center", &x509.Certificate{
+		SerialNumber: big.NewInt(3),
+		IPAddresses:  []net.IP{net.ParseIP("127.0.0.1")},
+		DNSNames:     []string{"center.local"},
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth},
+	}, ca, caKey)
+	writeTestCert(t, dir, "node", &x509.Certificate{
+		SerialNumber: big.NewInt(4),
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageClientAuth},
+	}, ca, caKey)
+
+	file := func(name string) string { return filepath.Join(dir, name) }
+	server := TLSConfig{Enable: true, CertFile: file("center.pem"), KeyFile: file("center.key")}
+	mtls := server
+	mtls.RequireClientCert, mtls.CAFile = true, file("ca.pem")
+	client := TLSConfig{Enable: true, CAFile: file("ca.pem")}
+	withCert := client
+	withCert.CertFile, withCert.KeyFile = file("node.pem"), file("node.key")
+
+	tests := []struct {
+		name   string
+		server TLSConfig
+		client TLSConfig
+		ok     bool
+	}{
+		{"tls", server, client, true},
+		{"server name", server, TLSConfig{CAFile: file("ca.pem"), ServerName: "center.local"}, true},
+		{"wrong server name", server, TLSConfig{CAFile: file("ca.pem"), ServerName: "other.local"}, false},
+		{"untrusted center", server, TLSConfig{CAFile: file("other-ca.pem")}, false},
+		{"mtls", mtls, withCert, true},
+		{"mtls without client cert", mtls, client, false},
+	}
+
+	for _, tt := range tests {
+		if err := testHandshake(t, tt.server, tt.client); (err == nil) != tt.ok {
+			t.Errorf("%s: handshake = %v, want ok %v", tt.name, err, tt.ok)
+		}
+	}
+
+	if _, err := (TLSConfig{CAFile: file("ca.key")}).ClientConfig("127.0.0.1:1"); err == nil {
+		t.Errorf("ClientConfig() should fail without certificate in ca file")
+	}
+}
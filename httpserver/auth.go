@@ -0,0 +1,107 @@
+package httpserver
+
+import (
+	"fmt"
+	"gitlab.forceup.in/zengliang/rpc2-center/common"
+	"net/http"
+	"strings"
+)
+
+// api key鉴权和限流
+type ApiKeyAuth struct {
+	enable bool
+
+	keys        map[string]common.ApiKey
+	keyLimiters map[string]*TokenBucket
+
+	serviceLimiters map[string]*TokenBucket
+	defaultLimiter  *KeyedLimiter
+
+	serviceOf func(req *http.Request) string
+}
+
+// serviceOf获取请求的目标服务(version.name), 用于按服务限流, 返回空时不按服务限流(如服务没有注册)
+func NewApiKeyAuth(conf common.HttpAuthConfig, keys []common.ApiKey,
+	serviceOf func(req *http.Request) string) *ApiKeyAuth {
+	auth := &ApiKeyAuth{
+		enable:          conf.Enable,
+		keys:            make(map[string]common.ApiKey),
+		keyLimiters:     make(map[string]*TokenBucket),
+		serviceLimiters: make(map[string]*TokenBucket),
+		serviceOf:       serviceOf,
+	}
+
+	for _, key := range keys {
+		auth.keys[key.Key] = key
+		auth.keyLimiters[key.Key] = NewTokenBucket(key.RateLimit)
+	}
+	for srvKey, limit := range conf.Services {
+		if srvKey == "*" {
+			auth.defaultLimiter = NewKeyedLimiter(limit)
+			continue
+		}
+		auth.serviceLimiters[strings.ToLower(srvKey)] = NewTokenBucket(limit)
+	}
+
+	return auth
+}
+
+// 从header或query中获取api key
+func ApiKeyFromRequest(req *http.Request) string {
+	if key := req.Header.Get(common.HeaderApiKey); key != "" {
+		return key
+	}
+	return req.URL.Query().Get(common.QueryApiKey)
+}
+
+func (auth *ApiKeyAuth) Wrap(next func(http.ResponseWriter, *http.Request)) func(http.ResponseWriter, *http.Request) {
+	return func(w http.ResponseWriter, req *http.Request) {
+		key := ApiKeyFromRequest(req)
+
+		_, ok := auth.keys[key]
+		if auth.enable && (key == "" || !ok) {
+			ResponseError(w, http.StatusUnauthorized, common.ErrUnauthorized, "invalid api key")
+			return
+		}
+
+		if ok && !auth.keyLimiters[key].Allow() {
+			ResponseError(w, http.StatusTooManyRequests, common.ErrRateLimited, "api key rate limited")
+			return
+		}
+
+		if srvKey := auth.serviceOfRequest(req); srvKey != "" {
+			limiter, ok := auth.serviceLimiters[srvKey]
+			if ok && !limiter.Allow() || !ok && !auth.defaultLimiter.Allow(srvKey) {
+				ResponseError(w, http.StatusTooManyRequests, common.ErrRateLimited, "service %s rate limited", srvKey)
+				return
+			}
+		}
+
+		next(w, req)
+	}
+}
+
+func (auth *ApiKeyAuth) serviceOfRequest(req *http.Request) string {
+	if auth.serviceOf == nil {
+		return ""
+	}
+	return auth.serviceOf(req)
+}
+
+// 设置跨域访问的header, 在鉴权之前设置, 浏览器才能读到鉴权失败的应答
+func WithCors(next func(http.ResponseWriter, *http.Request)) func(http.ResponseWriter, *http.Request) {
+	return func(w http.ResponseWriter, req *http.Request) {
+		w.Header().Set("Access-Control-Allow-Origin", "*")                                   //允许访问所有域
+		w.Header().Add("Access-Control-Allow-Headers", "Content-Type, "+common.HeaderApiKey) //header的类型
+		next(w, req)
+	}
+}
+
+// 输出错误应答
+func ResponseError(w http.ResponseWriter, status int, code common.ErrCode, err_fmt string, args ...interface{}) {
+	res := common.HttpUserResponse{Err: code, ErrMsg: fmt.Sprintf(err_fmt, args...)}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	ResponseDataByIndent(w, res)
+}
@@ -0,0 +1,39 @@
+package httpserver
+
+import (
+	"gitlab.forceup.in/zengliang/rpc2-center/common"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestApiKeyAuth(t *testing.T) {
+	keys := []common.ApiKey{{Key: "k1", Name: "app"}}
+
+	tests := []struct {
+		name   string
+		enable bool
+		key    string
+		want   int
+	}{
+		{"disabled", false, "", http.StatusOK},
+		{"missing key", true, "", http.StatusUnauthorized},
+		{"invalid key", true, "x", http.StatusUnauthorized},
+		{"valid key", true, "k1", http.StatusOK},
+	}
+
+	for _, tt := range tests {
+		auth := NewApiKeyAuth(common.HttpAuthConfig{Enable: tt.enable}, keys, nil)
+		handler := auth.Wrap(func(w http.ResponseWriter, req *http.Request) {})
+
+		req := httptest.NewRequest(http.MethodGet, "/discover", nil)
+		if tt.key != "" {
+			req.Header.Set(common.HeaderApiKey, tt.key)
+		}
+		w := httptest.NewRecorder()
+		handler(w, req)
+		if w.Code != tt.want {
+			t.Errorf("%s: status = %d, want %d", tt.name, w.Code, tt.want)
+		}
+	}
+}
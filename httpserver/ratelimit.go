@@ -0,0 +1,119 @@
+package httpserver
+
+import (
+	"gitlab.forceup.in/zengliang/rpc2-center/common"
+	"sync"
+	"time"
+)
+
+const maxKeyedBuckets = 10000
+
+type (
+	// 令牌桶
+	TokenBucket struct {
+		mu     sync.Mutex
+		rate   float64
+		burst  float64
+		tokens float64
+		last   time.Time
+	}
+
+	// 按key分别限流, 最多保存maxKeyedBuckets个key, 空闲的key会被清理
+	KeyedLimiter struct {
+		mu      sync.Mutex
+		limit   common.RateLimit
+		buckets map[string]*TokenBucket
+	}
+)
+
+// limit.Rate小于等于0时返回nil, 表示不限流
+func NewTokenBucket(limit common.RateLimit) *TokenBucket {
+	if limit.Rate <= 0 {
+		return nil
+	}
+
+	burst := float64(limit.Burst)
+	if burst <= 0 {
+		burst = limit.Rate
+	}
+	if burst < 1 {
+		burst = 1
+	}
+
+	return &TokenBucket{
+		rate:   limit.Rate,
+		burst:  burst,
+		tokens: burst,
+		last:   time.Now(),
+	}
+}
+
+// 取一个令牌, 没有令牌时返回false
+func (tb *TokenBucket) Allow() bool {
+	if tb == nil {
+		return true
+	}
+
+	tb.mu.Lock()
+	defer tb.mu.Unlock()
+
+	now := time.Now()
+	tb.tokens += now.Sub(tb.last).Seconds() * tb.rate
+	if tb.tokens > tb.burst {
+		tb.tokens = tb.burst
+	}
+	tb.last = now
+
+	if tb.tokens < 1 {
+		return false
+	}
+	tb.tokens--
+	return true
+}
+
+func NewKeyedLimiter(limit common.RateLimit) *KeyedLimiter {
+	return &KeyedLimiter{
+		limit:   limit,
+		buckets: make(map[string]*TokenBucket),
+	}
+}
+
+func (kl *KeyedLimiter) Allow(key string) bool {
+	if kl == nil || kl.limit.Rate <= 0 {
+		return true
+	}
+
+	kl.mu.Lock()
+	tb, ok := kl.buckets[key]
+	if !ok {
+		if len(kl.buckets) >= maxKeyedBuckets {
+			kl.evictIdle()
+		}
+		if len(kl.buckets) >= maxKeyedBuckets {
+			kl.mu.Unlock()
+			return false
+		}
+		tb = NewTokenBucket(kl.limit)
+		kl.buckets[key] = tb
+	}
+	kl.mu.Unlock()
+
+	return tb.Allow()
+}
+
+// 清理已经补满令牌的桶, 重新创建的桶也是满的, 所以不影响限流, 需要持有mu
+func (kl *KeyedLimiter) evictIdle() {
+	now := time.Now()
+	for key, tb := range kl.buckets {
+		if tb.idle(now) {
+			delete(kl.buckets, key)
+		}
+	}
+}
+
+func (tb *TokenBucket) idle(now time.Time) bool {
+	tb.mu.Lock()
+	defer tb.mu.Unlock()
+
+	return tb.tokens+now.Sub(tb.last).Seconds()*tb.rate >= tb.burst
+}
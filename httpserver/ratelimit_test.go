@@ -0,0 +1,104 @@
+package httpserver
+
+import (
+	"gitlab.forceup.in/zengliang/rpc2-center/common"
+	"strconv"
+	"testing"
+	"time"
+)
+
+func TestTokenBucket(t *testing.T) {
+	tests := []struct {
+		name  string
+		limit common.RateLimit
+		calls int
+		want  int // 允许的次数
+	}{
+		{"no limit", common.RateLimit{}, 100, 100},
+		{"burst", common.RateLimit{Rate: 0.001, Burst: 5}, 10, 5},
+		{"burst defaults to rate", common.RateLimit{Rate: 3}, 10, 3},
+		{"burst at least one", common.RateLimit{Rate: 0.001}, 10, 1},
+	}
+
+	for _, tt := range tests {
+		tb := NewTokenBucket(tt.limit)
+		allowed := 0
+		for i := 0; i < tt.calls; i++ {
+			if tb.Allow() {
+				allowed++
+			}
+		}
+		if allowed != tt.want {
+			t.Errorf("%s: allowed %d, want %d", tt.name, allowed, tt.want)
+		}
+	}
+}
+
+func TestTokenBucketRefill(t *testing.T) {
+	tb := NewTokenBucket(common.RateLimit{Rate: 1, Burst: 1})
+	if !tb.Allow() || tb.Allow() {
+		t.Fatalf("expected one token")
+	}
+
+	tb.last = tb.last.Add(-time.Second)
+	if !tb.Allow() {
+		t.Errorf("expected a token after one second")
+	}
+}
+
+func TestKeyedLimiter(t *testing.T) {
+	kl := NewKeyedLimiter(common.RateLimit{Rate: 0.001, Burst: 2})
+
+	tests := []struct {
+		key  string
+		want bool
+	}{
+		{"a", true},
+		{"a", true},
+		{"a", false},
+		{"b", true},
+		{"b", true},
+		{"b", false},
+	}
+
+	for i, tt := range tests {
+		if got := kl.Allow(tt.key); got != tt.want {
+			t.Errorf("step %d: Allow(%q) = %v, want %v", i, tt.key, got, tt.want)
+		}
+	}
+
+	var nilLimiter *KeyedLimiter
+	if !nilLimiter.Allow("a") {
+		t.Errorf("nil limiter should allow")
+	}
+}
+
+// key的数量达到上限时, 清理空闲的桶, 没有空闲的桶时拒绝新的key
+func TestKeyedLimiterMaxBuckets(t *testing.T) {
+	tests := []struct {
+		name    string
+		consume bool // 是否用掉每个桶的令牌
+		want    bool
+	}{
+		{"evict idle buckets", false, true},
+		{"all buckets busy", true, false},
+	}
+
+	for _, tt := range tests {
+		kl := NewKeyedLimiter(common.RateLimit{Rate: 0.001, Burst: 1})
+		for i := 0; i < maxKeyedBuckets; i++ {
+			key := strconv.Itoa(i)
+			kl.buckets[key] = NewTokenBucket(kl.limit)
+			if tt.consume {
+				kl.buckets[key].Allow()
+			}
+		}
+
+		if got := kl.Allow("new"); got != tt.want {
+			t.Errorf("%s: Allow(new) = %v, want %v", tt.name, got, tt.want)
+		}
+		if len(kl.buckets) > maxKeyedBuckets {
+			t.Errorf("%s: %d buckets, want at most %d", tt.name, len(kl.buckets), maxKeyedBuckets)
+		}
+	}
+}
@@ -2,6 +2,7 @@ package httpserver
 
 import (
 	"bytes"
+	"context"
 	"encoding/json"
 	"fmt"
 	"gitlab.forceup.in/Payment/backend/l4g"
@@ -69,7 +70,7 @@ func (hs *HttpServer) Start(endpoint string) {
 
 	go func() {
 		err := hs.server.ListenAndServe()
-		if err != nil {
+		if err != nil && err != http.ErrServerClosed {
 			l4g.BuildL4g("rpc2-center", "rpc2-center").Fatal(err.Error())
 		}
 	}()
@@ -83,3 +84,8 @@ func (hs *HttpServer) RegisterHandler(pattern string, handler func(http.Response
 func (hs *HttpServer) Stop() error {
 	return hs.server.Close()
 }
+
+// 停止接受新连接, 等待正在处理的请求结束, ctx结束时返回ctx.Err()
+func (hs *HttpServer) Shutdown(ctx context.Context) error {
+	return hs.server.Shutdown(ctx)
+}
@@ -83,13 +83,15 @@ func runCenter() error {
 		fmt.Scanln(&input)
 
 		if input == "q" {
-			cancel()
 			break
 		}
 	}
 
 	center.Info("Waiting all routine quit...")
-	rpc.StopCenter(center)
+	shutdownCtx, shutdownCancel := context.WithTimeout(context.Background(), time.Second*10)
+	center.Shutdown(shutdownCtx)
+	shutdownCancel()
+	cancel()
 	center.Info("All routine is quit...")
 
 	center.Info("wait 10 second to exit...")
@@ -110,7 +112,7 @@ func runNode() error {
 		return err
 	}
 
-	node, err := rpc.NewNode(cfg, Meta, &loger.MyLoger{}, func(status common.ConnectStatus) {})
+	node, err := rpc.NewNode(cfg, Meta, &loger.MyLoger{}, func(status common.ConnectStatus, attempt int) {})
 	if err != nil {
 		return err
 	}
@@ -139,13 +141,13 @@ func runNode() error {
 		fmt.Scanln(&input)
 
 		if input == "q" {
-			cancel()
 			break
 		}
 	}
 
 	node.Info("Waiting all routine quit...")
 	rpc.StopNode(node)
+	cancel()
 	node.Info("All routine is quit...")
 
 	node.Info("wait 10 second to exit...")
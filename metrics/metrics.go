@@ -0,0 +1,325 @@
+package metrics
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"math"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// Prometheus text format(0.0.4)的简单实现, 不依赖外部服务
+type (
+	Registry struct {
+		mu      sync.Mutex
+		metrics []metric
+	}
+
+	metric interface {
+		write(w io.Writer)
+	}
+
+	desc struct {
+		name   string
+		help   string
+		kind   string
+		labels []string
+	}
+
+	sample struct {
+		labelValues []string
+		value       float64
+	}
+
+	// 带label的计数器
+	CounterVec struct {
+		desc
+		mu      sync.Mutex
+		samples map[string]*sample
+	}
+
+	// 带label的仪表
+	GaugeVec struct {
+		CounterVec
+	}
+
+	// 采集时调用fn获取数值的仪表
+	GaugeFunc struct {
+		desc
+		fn func(set func(value float64, labelValues ...string))
+	}
+
+	histogramSample struct {
+		labelValues []string
+		counts      []uint64
+		count       uint64
+		sum         float64
+	}
+
+	// 带label的直方图
+	HistogramVec struct {
+		desc
+		buckets []float64
+		mu      sync.Mutex
+		samples map[string]*histogramSample
+	}
+)
+
+// 默认的耗时分桶(秒)
+var DefBuckets = []float64{.005, .01, .025, .05, .1, .25, .5, 1, 2.5, 5, 10}
+
+func NewRegistry() *Registry {
+	return &Registry{}
+}
+
+func (r *Registry) register(m metric) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	r.metrics = append(r.metrics, m)
+}
+
+func (r *Registry) NewCounter(name, help string, labels ...string) *CounterVec {
+	c := &CounterVec{
+		desc:    desc{name: name, help: help, kind: "counter", labels: labels},
+		samples: make(map[string]*sample),
+	}
+	r.register(c)
+	return c
+}
+
+func (r *Registry) NewGauge(name, help string, labels ...string) *GaugeVec {
+	g := &GaugeVec{CounterVec{
+		desc:    desc{name: name, help: help, kind: "gauge", labels: labels},
+		samples: make(map[string]*sample),
+	}}
+	r.register(g)
+	return g
+}
+
+func (r *Registry) NewGaugeFunc(name, help string, labels []string,
+	fn func(set func(value float64, labelValues ...string))) *GaugeFunc {
+	g := &GaugeFunc{
+		desc: desc{name: name, help: help, kind: "gauge", labels: labels},
+		fn:   fn,
+	}
+	r.register(g)
+	return g
+}
+
+func (r *Registry) NewHistogram(name, help string, buckets []float64, labels ...string) *HistogramVec {
+	if len(buckets) == 0 {
+		buckets = DefBuckets
+	}
+	h := &HistogramVec{
+		desc:    desc{name: name, help: help, kind: "histogram", labels: labels},
+		buckets: buckets,
+		samples: make(map[string]*histogramSample),
+	}
+	r.register(h)
+	return h
+}
+
+// 按注册顺序输出所有指标
+func (r *Registry) WriteText(w io.Writer) {
+	r.mu.Lock()
+	metrics := append([]metric{}, r.metrics...)
+	r.mu.Unlock()
+
+	bw := bufio.NewWriter(w)
+	for _, m := range metrics {
+		m.write(bw)
+	}
+	bw.Flush()
+}
+
+func (r *Registry) ServeHTTP(w http.ResponseWriter, req *http.Request) {
+	w.Header().Set("Content-Type", "text/plain; version=0.0.4; charset=utf-8")
+	r.WriteText(w)
+}
+
+func (c *CounterVec) Inc(labelValues ...string) {
+	c.Add(1, labelValues...)
+}
+
+func (c *CounterVec) Add(v float64, labelValues ...string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.get(labelValues).value += v
+}
+
+func (c *CounterVec) get(labelValues []string) *sample {
+	key := strings.Join(labelValues, "\xff")
+	s, ok := c.samples[key]
+	if !ok {
+		s = &sample{labelValues: append([]string{}, labelValues...)}
+		c.samples[key] = s
+	}
+	return s
+}
+
+func (c *CounterVec) write(w io.Writer) {
+	c.mu.Lock()
+	samples := make([]sample, 0, len(c.samples))
+	for _, s := range c.samples {
+		samples = append(samples, *s)
+	}
+	c.mu.Unlock()
+
+	c.writeSamples(w, samples)
+}
+
+// 遍历当前所有label的数值
+func (c *CounterVec) Each(fn func(value float64, labelValues ...string)) {
+	c.mu.Lock()
+	samples := make([]sample, 0, len(c.samples))
+	for _, s := range c.samples {
+		samples = append(samples, *s)
+	}
+	c.mu.Unlock()
+
+	for _, s := range samples {
+		fn(s.value, s.labelValues...)
+	}
+}
+
+func (g *GaugeVec) Set(v float64, labelValues ...string) {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+
+	g.get(labelValues).value = v
+}
+
+func (g *GaugeVec) Dec(labelValues ...string) {
+	g.Add(-1, labelValues...)
+}
+
+func (g *GaugeFunc) write(w io.Writer) {
+	samples := []sample{}
+	g.fn(func(value float64, labelValues ...string) {
+		samples = append(samples, sample{labelValues: labelValues, value: value})
+	})
+
+	g.writeSamples(w, samples)
+}
+
+func (h *HistogramVec) Observe(v float64, labelValues ...string) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	key := strings.Join(labelValues, "\xff")
+	s, ok := h.samples[key]
+	if !ok {
+		s = &histogramSample{
+			labelValues: append([]string{}, labelValues...),
+			counts:      make([]uint64, len(h.buckets)),
+		}
+		h.samples[key] = s
+	}
+
+	for i, upper := range h.buckets {
+		if v <= upper {
+			s.counts[i]++
+		}
+	}
+	s.count++
+	s.sum += v
+}
+
+func (h *HistogramVec) write(w io.Writer) {
+	h.mu.Lock()
+	samples := make([]histogramSample, 0, len(h.samples))
+	for _, s := range h.samples {
+		cp := *s
+		cp.counts = append([]uint64{}, s.counts...)
+		samples = append(samples, cp)
+	}
+	h.mu.Unlock()
+
+	sort.Slice(samples, func(i, j int) bool {
+		return lessLabels(samples[i].labelValues, samples[j].labelValues)
+	})
+
+	h.writeHeader(w)
+	bucketLabels := append(append([]string{}, h.labels...), "le")
+	for _, s := range samples {
+		for i, upper := range h.buckets {
+			values := append(append([]string{}, s.labelValues...), formatFloat(upper))
+			fmt.Fprintf(w, "%s_bucket%s %d\n", h.name, formatLabels(bucketLabels, values), s.counts[i])
+		}
+		values := append(append([]string{}, s.labelValues...), "+Inf")
+		fmt.Fprintf(w, "%s_bucket%s %d\n", h.name, formatLabels(bucketLabels, values), s.count)
+		fmt.Fprintf(w, "%s_sum%s %s\n", h.name, formatLabels(h.labels, s.labelValues), formatFloat(s.sum))
+		fmt.Fprintf(w, "%s_count%s %d\n", h.name, formatLabels(h.labels, s.labelValues), s.count)
+	}
+}
+
+func (d *desc) writeHeader(w io.Writer) {
+	fmt.Fprintf(w, "# HELP %s %s\n", d.name, strings.Replace(d.help, "\n", " ", -1))
+	fmt.Fprintf(w, "# TYPE %s %s\n", d.name, d.kind)
+}
+
+func (d *desc) writeSamples(w io.Writer, samples []sample) {
+	sort.Slice(samples, func(i, j int) bool {
+		return lessLabels(samples[i].labelValues, samples[j].labelValues)
+	})
+
+	d.writeHeader(w)
+	for _, s := range samples {
+		fmt.Fprintf(w, "%s%s %s\n", d.name, formatLabels(d.labels, s.labelValues), formatFloat(s.value))
+	}
+}
+
+func formatLabels(names, values []string) string {
+	if len(names) == 0 {
+		return ""
+	}
+
+	var b strings.Builder
+	b.WriteByte('{')
+	for i, name := range names {
+		if i > 0 {
+			b.WriteByte(',')
+		}
+		value := ""
+		if i < len(values) {
+			value = values[i]
+		}
+		b.WriteString(name)
+		b.WriteString(`="`)
+		b.WriteString(escapeLabel(value))
+		b.WriteByte('"')
+	}
+	b.WriteByte('}')
+	return b.String()
+}
+
+var labelEscaper = strings.NewReplacer(`\`, `\\`, "\n", `\n`, `"`, `\"`)
+
+func escapeLabel(s string) string {
+	return labelEscaper.Replace(s)
+}
+
+func formatFloat(v float64) string {
+	switch {
+	case math.IsInf(v, 1):
+		return "+Inf"
+	case math.IsInf(v, -1):
+		return "-Inf"
+	}
+	return strconv.FormatFloat(v, 'g', -1, 64)
+}
+
+func lessLabels(a, b []string) bool {
+	for i := 0; i < len(a) && i < len(b); i++ {
+		if a[i] != b[i] {
+			return a[i] < b[i]
+		}
+	}
+	return len(a) < len(b)
+}
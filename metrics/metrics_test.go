@@ -0,0 +1,113 @@
+package metrics
+
+import (
+	"bytes"
+	"math"
+	"strings"
+	"testing"
+)
+
+func TestFormatLabels(t *testing.T) {
+	tests := []struct {
+		names  []string
+		values []string
+		want   string
+	}{
+		{nil, nil, ""},
+		{[]string{"type"}, []string{"call"}, `{type="call"}`},
+		{[]string{"a", "b"}, []string{"1"}, `{a="1",b=""}`},
+		{[]string{"m"}, []string{"a\"b\\c\nd"}, `{m="a\"b\\c\nd"}`},
+	}
+
+	for _, tt := range tests {
+		if got := formatLabels(tt.names, tt.values); got != tt.want {
+			t.Errorf("formatLabels(%v, %v) = %s, want %s", tt.names, tt.values, got, tt.want)
+		}
+	}
+}
+
+func TestFormatFloat(t *testing.T) {
+	tests := []struct {
+		v    float64
+		want string
+	}{
+		{0, "0"},
+		{1, "1"},
+		{0.25, "0.25"},
+		{1e21, "1e+21"},
+		{math.Inf(1), "+Inf"},
+		{math.Inf(-1), "-Inf"},
+	}
+
+	for _, tt := range tests {
+		if got := formatFloat(tt.v); got != tt.want {
+			t.Errorf("formatFloat(%v) = %s, want %s", tt.v, got, tt.want)
+		}
+	}
+}
+
+func TestRegistryWriteText(t *testing.T) {
+	r := NewRegistry()
+
+	requests := r.NewCounter("rpc_requests_total", "Total requests.", "type", "code")
+	requests.Inc("call", "0")
+	requests.Add(2, "call", "0")
+	requests.Inc("notify", "1001")
+
+	inFlight := r.NewGauge("rpc_in_flight", "In flight requests.")
+	inFlight.Set(3)
+	inFlight.Dec()
+
+	r.NewGaugeFunc("rpc_nodes", "Registered nodes.", []string{"service"}, func(set func(float64, ...string)) {
+		set(2, "v1.pay")
+	})
+
+	duration := r.NewHistogram("rpc_duration_seconds", "Request duration.", []float64{0.1, 1}, "type")
+	duration.Observe(0.05, "call")
+	duration.Observe(0.5, "call")
+	duration.Observe(5, "call")
+
+	buf := &bytes.Buffer{}
+	r.WriteText(buf)
+	got := buf.String()
+
+	want := []string{
+		"# HELP rpc_requests_total Total requests.",
+		"# TYPE rpc_requests_total counter",
+		`rpc_requests_total{type="call",code="0"} 3`,
+		`rpc_requests_total{type="notify",code="1001"} 1`,
+		"# TYPE rpc_in_flight gauge",
+		"rpc_in_flight 2",
+		`rpc_nodes{service="v1.pay"} 2`,
+		"# TYPE rpc_duration_seconds histogram",
+		`rpc_duration_seconds_bucket{type="call",le="0.1"} 1`,
+		`rpc_duration_seconds_bucket{type="call",le="1"} 2`,
+		`rpc_duration_seconds_bucket{type="call",le="+Inf"} 3`,
+		`rpc_duration_seconds_sum{type="call"} 5.55`,
+		`rpc_duration_seconds_count{type="call"} 3`,
+	}
+
+	for _, line := range want {
+		if !strings.Contains(got, line+"\n") {
+			t.Errorf("missing line %q in:\n%s", line, got)
+		}
+	}
+	if strings.Index(got, "rpc_requests_total") > strings.Index(got, "rpc_duration_seconds") {
+		t.Errorf("metrics are not written in register order")
+	}
+}
+
+func TestCounterEach(t *testing.T) {
+	c := NewRegistry().NewCounter("c", "", "k")
+	c.Inc("a")
+	c.Add(2, "b")
+
+	got := map[string]float64{}
+	c.Each(func(value float64, labelValues ...string) {
+		got[labelValues[0]] = value
+	})
+
+	if len(got) != 2 || got["a"] != 1 || got["b"] != 2 {
+		t.Errorf("Each() = %v", got)
+	}
+}
@@ -0,0 +1,82 @@
+package rpc
+
+import (
+	"context"
+	"gitlab.forceup.in/zengliang/rpc2-center/common"
+	"path"
+	"strings"
+)
+
+type (
+	// 调用权限检查
+	accessControl struct {
+		conf common.AclConfig
+	}
+
+	// center自己发起的调用, 只在进程内的context中设置, 不会随请求传输
+	internalCallKey struct{}
+)
+
+func newAccessControl(conf common.AclConfig) *accessControl {
+	for i := range conf.Rules {
+		for j := range conf.Rules[i].From {
+			conf.Rules[i].From[j] = strings.ToLower(conf.Rules[i].From[j])
+		}
+		for j := range conf.Rules[i].Allow {
+			conf.Rules[i].Allow[j] = strings.ToLower(conf.Rules[i].Allow[j])
+		}
+	}
+	return &accessControl{conf: conf}
+}
+
+// caller是否可以调用method(version.name.function)
+func (ac *accessControl) allowed(caller, method string) bool {
+	if !ac.conf.Enable {
+		return true
+	}
+
+	caller = strings.ToLower(caller)
+	method = strings.ToLower(method)
+	for _, rule := range ac.conf.Rules {
+		if !matchAny(rule.From, caller) {
+			continue
+		}
+		if matchAny(rule.Allow, method) {
+			return true
+		}
+	}
+	return false
+}
+
+func matchAny(patterns []string, s string) bool {
+	for _, pattern := range patterns {
+		if ok, _ := path.Match(pattern, s); ok {
+			return true
+		}
+	}
+	return false
+}
+
+// 请求的调用方
+func getCaller(req *common.Request) string {
+	if caller, ok := req.Context[common.ContextKeyCaller].(string); ok && caller != "" {
+		return caller
+	}
+	return common.CallerAnonymous
+}
+
+func setCaller(req *common.Request, caller string) {
+	if req.Context == nil {
+		req.Context = make(common.Context)
+	}
+	req.Context[common.ContextKeyCaller] = caller
+}
+
+func withInternalCall(ctx context.Context) context.Context {
+	return context.WithValue(ctx, internalCallKey{}, true)
+}
+
+func isInternalCall(ctx context.Context) bool {
+	internal, _ := ctx.Value(internalCallKey{}).(bool)
+	return internal
+}
@@ -0,0 +1,79 @@
+package rpc
+
+import (
+	"context"
+	"gitlab.forceup.in/zengliang/rpc2-center/common"
+	"gitlab.forceup.in/zengliang/rpc2-center/loger"
+	"testing"
+)
+
+func TestAccessControl(t *testing.T) {
+	acl := newAccessControl(common.AclConfig{Enable: true, Rules: []common.AclRule{
+		{From: []string{"v1.Order"}, Allow: []string{"v1.pay.*"}},
+		{From: []string{"apikey:*"}, Allow: []string{"v1.pay.query"}},
+		{From: []string{"*"}, Allow: []string{"v1.public.*"}},
+	}})
+
+	tests := []struct {
+		caller string
+		method string
+		want   bool
+	}{
+		{"v1.order", "v1.pay.charge", true},
+		{"V1.ORDER", "V1.Pay.Refund", true},
+		{"v1.order", "v1.user.get", false},
+		{"apikey:app", "v1.pay.query", true},
+		{"apikey:app", "v1.pay.charge", false},
+		{common.CallerAnonymous, "v1.public.ping", true},
+		{common.CallerAnonymous, "v1.pay.query", false},
+	}
+
+	for _, tt := range tests {
+		if got := acl.allowed(tt.caller, tt.method); got != tt.want {
+			t.Errorf("allowed(%s, %s) = %v, want %v", tt.caller, tt.method, got, tt.want)
+		}
+	}
+
+	if !newAccessControl(common.AclConfig{}).allowed(common.CallerAnonymous, "v1.pay.charge") {
+		t.Errorf("disabled acl should allow all calls")
+	}
+}
+
+// center自己发起的调用不检查权限, 没有调用方的请求按anonymous检查
+func TestCheckPermission(t *testing.T) {
+	conf := common.ConfigCenter{Acl: common.AclConfig{Enable: true, Rules: []common.AclRule{
+		{From: []string{"v1.order"}, Allow: []string{"v1.pay.*"}},
+	}}}
+	c, err := NewCenter(conf, "", &loger.MyLoger{}, nil, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name     string
+		caller   string
+		internal bool
+		want     common.ErrCode
+	}{
+		{"allowed", "v1.order", false, common.ErrOk},
+		{"denied", "v1.user", false, common.ErrNoPermission},
+		{"anonymous", "", false, common.ErrNoPermission},
+		{"internal", "", true, common.ErrOk},
+	}
+
+	for _, tt := range tests {
+		req := &common.Request{Method: common.NewMethod("v1", "pay", "charge")}
+		if tt.caller != "" {
+			setCaller(req, tt.caller)
+		}
+		ctx := context.Background()
+		if tt.internal {
+			ctx = withInternalCall(ctx)
+		}
+
+		res := &common.Response{}
+		if ok := c.checkPermission(ctx, req, res); ok != (tt.want == common.ErrOk) || res.Data.Err != tt.want {
+			t.Errorf("%s: checkPermission() = %v, err %d, want %d", tt.name, ok, res.Data.Err, tt.want)
+		}
+	}
+}
@@ -0,0 +1,142 @@
+package rpc
+
+import (
+	"crypto/hmac"
+	"encoding/json"
+	"github.com/zl03jsj/rpc2"
+	"gitlab.forceup.in/zengliang/rpc2-center/common"
+	"gitlab.forceup.in/zengliang/rpc2-center/httpserver"
+	"net/http"
+	"strings"
+)
+
+// 管理接口:
+// GET  /admin/services                  所有服务和节点
+// GET  /admin/instances?service=v1.pay  节点列表, 可以按服务过滤
+// GET  /admin/instances/{id}            节点详情
+// POST /admin/instances/{id}/disconnect 断开节点
+// POST /admin/instances/{id}/cordon     不再向节点分配请求
+// POST /admin/instances/{id}/uncordon   恢复向节点分配请求
+// GET  /admin/traffic                   流量规则和命中次数
+// PUT  /admin/traffic                   替换流量规则, body为规则数组
+func (c *Center) handleAdmin(w http.ResponseWriter, req *http.Request) {
+	if !c.isAdmin(req) {
+		httpserver.ResponseError(w, http.StatusUnauthorized, common.ErrUnauthorized, "invalid admin token")
+		return
+	}
+
+	paths := strings.Split(strings.Trim(strings.TrimPrefix(req.URL.Path, "/admin/"), "/"), "/")
+	switch {
+	case len(paths) == 1 && paths[0] == "services" && req.Method == http.MethodGet:
+		c.responseAdmin(w, c.ListSrv())
+	case len(paths) == 1 && paths[0] == "instances" && req.Method == http.MethodGet:
+		c.responseAdmin(w, c.GetNodes(req.URL.Query().Get("service")))
+	case len(paths) == 2 && paths[0] == "instances" && req.Method == http.MethodGet:
+		if status, ok := c.GetNode(paths[1]); ok {
+			c.responseAdmin(w, status)
+		} else {
+			httpserver.ResponseError(w, http.StatusNotFound, common.ErrNotFindService, "instance %s not found", paths[1])
+		}
+	case len(paths) == 3 && paths[0] == "instances" && req.Method == http.MethodPost:
+		var err error
+		switch paths[2] {
+		case "disconnect":
+			err = c.DisconnectNode(paths[1])
+		case "cordon":
+			err = c.CordonNode(paths[1], true)
+		case "uncordon":
+			err = c.CordonNode(paths[1], false)
+		default:
+			httpserver.ResponseError(w, http.StatusNotFound, common.ErrNotFindCaller, "unknown action %s", paths[2])
+			return
+		}
+		if err != nil {
+			httpserver.ResponseError(w, http.StatusNotFound, common.ErrNotFindService, "%s", err.Error())
+			return
+		}
+		c.Info("admin %s %s from %s", paths[2], paths[1], req.RemoteAddr)
+		c.responseAdmin(w, "ok")
+	case len(paths) == 1 && paths[0] == "traffic" && req.Method == http.MethodGet:
+		c.responseAdmin(w, c.GetTrafficRules())
+	case len(paths) == 1 && paths[0] == "traffic" && req.Method == http.MethodPut:
+		rules := []common.TrafficRule{}
+		if err := json.NewDecoder(req.Body).Decode(&rules); err != nil {
+			httpserver.ResponseError(w, http.StatusBadRequest, common.ErrDataCorrupted, "%s", err.Error())
+			return
+		}
+		if err := c.SetTrafficRules(rules); err != nil {
+			httpserver.ResponseError(w, http.StatusBadRequest, common.ErrDataCorrupted, "%s", err.Error())
+			return
+		}
+		c.Info("admin set %d traffic rules from %s", len(rules), req.RemoteAddr)
+		c.responseAdmin(w, c.GetTrafficRules())
+	default:
+		httpserver.ResponseError(w, http.StatusNotFound, common.ErrNotFindCaller, "unknown admin api %s %s", req.Method, req.URL.Path)
+	}
+}
+
+// 校验Authorization: Bearer {admin_token}
+func (c *Center) isAdmin(req *http.Request) bool {
+	token := strings.TrimPrefix(req.Header.Get("Authorization"), "Bearer ")
+	return c.cfgCenter.AdminToken != "" && hmac.Equal([]byte(token), []byte(c.cfgCenter.AdminToken))
+}
+
+func (c *Center) responseAdmin(w http.ResponseWriter, result interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	httpserver.ResponseDataByIndent(w, common.HttpUserResponse{Result: result})
+}
+
+// 注册在本center上的节点, srvKey为空时返回所有节点
+func (c *Center) GetNodes(srvKey string) []common.NodeStatus {
+	c.rwMu.RLock()
+	defer c.rwMu.RUnlock()
+
+	nodes := []common.NodeStatus{}
+	for key, nodeGroup := range c.verNameMapNodeGroup {
+		if srvKey == "" || strings.EqualFold(srvKey, key) {
+			nodes = append(nodes, nodeGroup.GetNodeStatus()...)
+		}
+	}
+	return nodes
+}
+
+func (c *Center) GetNode(id string) (common.NodeStatus, bool) {
+	for _, status := range c.GetNodes("") {
+		if status.Id == id {
+			return status, true
+		}
+	}
+	return common.NodeStatus{}, false
+}
+
+// 断开节点连接, 节点会按重连策略重新注册
+func (c *Center) DisconnectNode(id string) error {
+	var client *rpc2.Client
+	c.rwMu.RLock()
+	for _, nodeGroup := range c.verNameMapNodeGroup {
+		if client = nodeGroup.GetClient(id); client != nil {
+			break
+		}
+	}
+	c.rwMu.RUnlock()
+
+	if client == nil {
+		return common.NewCodeError(common.ErrNotFindService, "instance %s not found", id)
+	}
+
+	c.disconnectClient(client)
+	return nil
+}
+
+// 设置是否停止向节点分配请求
+func (c *Center) CordonNode(id string, cordoned bool) error {
+	c.rwMu.RLock()
+	defer c.rwMu.RUnlock()
+
+	for _, nodeGroup := range c.verNameMapNodeGroup {
+		if nodeGroup.Cordon(id, cordoned) {
+			return nil
+		}
+	}
+	return common.NewCodeError(common.ErrNotFindService, "instance %s not found", id)
+}
@@ -3,6 +3,7 @@ package rpc
 import (
 	"fmt"
 	"gitlab.forceup.in/zengliang/rpc2-center/common"
+	"gitlab.forceup.in/zengliang/rpc2-center/loger"
 	"strings"
 	"sync"
 )
@@ -26,11 +27,15 @@ type (
 		apiCallerNameList []string
 		apiCallerInfoMap  map[string]*ApiCallerInfo
 
+		apiIdempotentNameList []string
+
 		apiNotifierNameList []string
 		apiNotifierInfoMap  map[string]*ApiNotifierInfo
 
 		beforeExec BeforApiCaller
 		rWMutex    sync.RWMutex
+
+		iLoger loger.ILoger // 记录带类型的notifier返回的错误, 可以为nil
 	}
 )
 
@@ -48,6 +53,10 @@ func (ag *ApiInfoGroup) SetOnBeforeExec(beforExec BeforApiCaller) {
 	ag.beforeExec = beforExec
 }
 
+func (ag *ApiInfoGroup) SetLoger(iLoger loger.ILoger) {
+	ag.iLoger = iLoger
+}
+
 func (ag *ApiInfoGroup) RegisterCaller(name string, handler ApiCaller) error {
 	ag.rWMutex.Lock()
 	defer ag.rWMutex.Unlock()
@@ -64,6 +73,19 @@ func (ag *ApiInfoGroup) RegisterCaller(name string, handler ApiCaller) error {
 	return nil
 }
 
+// 注册幂等的caller, center在调用失败时可以换节点重试
+func (ag *ApiInfoGroup) RegisterIdempotentCaller(name string, handler ApiCaller) error {
+	if err := ag.RegisterCaller(name, handler); err != nil {
+		return err
+	}
+
+	ag.rWMutex.Lock()
+	defer ag.rWMutex.Unlock()
+
+	ag.apiIdempotentNameList = append(ag.apiIdempotentNameList, strings.ToLower(name))
+	return nil
+}
+
 func (ag *ApiInfoGroup) RegisterNotifier(name string, handler ApiNotifier) error {
 	ag.rWMutex.Lock()
 	defer ag.rWMutex.Unlock()
@@ -87,6 +109,13 @@ func (ag *ApiInfoGroup) GetCallerNameList() []string {
 	return ag.apiCallerNameList
 }
 
+func (ag *ApiInfoGroup) GetIdempotentNameList() []string {
+	ag.rWMutex.RLock()
+	defer ag.rWMutex.RUnlock()
+
+	return ag.apiIdempotentNameList
+}
+
 func (ag *ApiInfoGroup) GetNotifierNameList() []string {
 	ag.rWMutex.RLock()
 	defer ag.rWMutex.RUnlock()
@@ -94,6 +123,23 @@ func (ag *ApiInfoGroup) GetNotifierNameList() []string {
 	return ag.apiNotifierNameList
 }
 
+func (ag *ApiInfoGroup) hasFunction(notify bool, name string) bool {
+	if ag == nil {
+		return false
+	}
+
+	ag.rWMutex.RLock()
+	defer ag.rWMutex.RUnlock()
+
+	name = strings.ToLower(name)
+	if notify {
+		_, ok := ag.apiNotifierInfoMap[name]
+		return ok
+	}
+	_, ok := ag.apiCallerInfoMap[name]
+	return ok
+}
+
 func (ag *ApiInfoGroup) HandleCall(req *common.Request, res *common.Response) {
 	ag.rWMutex.RLock()
 	defer ag.rWMutex.RUnlock()
@@ -0,0 +1,137 @@
+package rpc
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"gitlab.forceup.in/zengliang/rpc2-center/common"
+	"reflect"
+)
+
+var (
+	contextType = reflect.TypeOf((*context.Context)(nil)).Elem()
+	errorType   = reflect.TypeOf((*error)(nil)).Elem()
+)
+
+type typedHandler struct {
+	fn     reflect.Value
+	inType reflect.Type // 参数的指针类型
+	notify bool
+	group  *ApiInfoGroup
+}
+
+// 检查函数签名, 支持:
+// caller:   func(ctx context.Context, in *In) (out *Out, err error)
+// notifier: func(ctx context.Context, in *In) error
+func newTypedHandler(fn reflect.Value) (*typedHandler, error) {
+	t := fn.Type()
+	if t.Kind() != reflect.Func {
+		return nil, fmt.Errorf("handler must be a func, got %s", t)
+	}
+	if t.NumIn() != 2 || t.In(0) != contextType || t.In(1).Kind() != reflect.Ptr {
+		return nil, fmt.Errorf("handler %s must take (context.Context, *In)", t)
+	}
+
+	switch {
+	case t.NumOut() == 1 && t.Out(0) == errorType:
+		return &typedHandler{fn: fn, inType: t.In(1), notify: true}, nil
+	case t.NumOut() == 2 && t.Out(1) == errorType:
+		return &typedHandler{fn: fn, inType: t.In(1)}, nil
+	}
+	return nil, fmt.Errorf("handler %s must return (*Out, error) or error", t)
+}
+
+// 解析请求参数并调用函数, 返回结果和错误, 函数panic时返回ErrInternal
+func (h *typedHandler) invoke(req *common.Request) (out interface{}, err error) {
+	defer func() {
+		if r := recover(); r != nil {
+			out, err = nil, common.NewCodeError(common.ErrInternal, "%s panic: %v", req.Method.Function, r)
+		}
+	}()
+
+	in := reflect.New(h.inType.Elem())
+	if err := req.Data.GetValue(in.Interface()); err != nil {
+		return nil, common.NewCodeError(common.ErrDataCorrupted, "decode %s: %s", h.inType.Elem(), err.Error())
+	}
+
+	outs := h.fn.Call([]reflect.Value{reflect.ValueOf(req.Ctx()), in})
+
+	err, _ = outs[len(outs)-1].Interface().(error)
+	if h.notify || err != nil {
+		return nil, err
+	}
+	return outs[0].Interface(), nil
+}
+
+func (h *typedHandler) handleCall(req *common.Request, res *common.Response) {
+	out, err := h.invoke(req)
+	if err != nil {
+		setTypedError(res, err)
+		return
+	}
+	if err = res.SetOkResult(out); err != nil {
+		res.SetErrResult(common.ErrInternal, "encode result: %s", err.Error())
+	}
+}
+
+// 通知没有应答, 返回的错误只记录日志
+func (h *typedHandler) handleNotify(req *common.Request) {
+	if _, err := h.invoke(req); err != nil && h.group.iLoger != nil {
+		h.group.iLoger.Error("notify %s: %s", req.Method.Function, err.Error())
+	}
+}
+
+// CodeError按错误码返回, 其他错误都返回ErrInternal
+func setTypedError(res *common.Response, err error) {
+	var codeErr *common.CodeError
+	if errors.As(err, &codeErr) {
+		res.SetErrResult(codeErr.Code, "%s", codeErr.Msg)
+		return
+	}
+	res.SetErrResult(common.ErrInternal, "%s", err.Error())
+}
+
+// 注册带类型的函数, 按返回值注册为caller或notifier, 请求和应答的数据自动编解码
+func (ag *ApiInfoGroup) RegisterTyped(name string, fn interface{}) error {
+	h, err := newTypedHandler(reflect.ValueOf(fn))
+	if err != nil {
+		return fmt.Errorf("register %s: %s", name, err.Error())
+	}
+
+	h.group = ag
+	if h.notify {
+		return ag.RegisterNotifier(name, h.handleNotify)
+	}
+	return ag.RegisterCaller(name, h.handleCall)
+}
+
+// 注册obj所有签名符合RegisterTyped的导出方法, 以方法名作为函数名, 其他方法忽略
+func (ag *ApiInfoGroup) RegisterService(obj interface{}) error {
+	v := reflect.ValueOf(obj)
+	t := v.Type()
+
+	count := 0
+	for i := 0; i < t.NumMethod(); i++ {
+		h, err := newTypedHandler(v.Method(i))
+		if err != nil {
+			continue
+		}
+
+		name := t.Method(i).Name
+		h.group = ag
+		if h.notify {
+			err = ag.RegisterNotifier(name, h.handleNotify)
+		} else {
+			err = ag.RegisterCaller(name, h.handleCall)
+		}
+		if err != nil {
+			return err
+		}
+		count++
+	}
+
+	if count == 0 {
+		return fmt.Errorf("%s has no method like func(context.Context, *In) (*Out, error)", t)
+	}
+	return nil
+}
@@ -0,0 +1,111 @@
+package rpc
+
+import (
+	"context"
+	"errors"
+	"gitlab.forceup.in/zengliang/rpc2-center/common"
+	"gitlab.forceup.in/zengliang/rpc2-center/loger"
+	"testing"
+)
+
+type (
+	typedReq struct{ Amount int }
+	typedRes struct{ Amount int }
+
+	typedService struct{ notified int }
+)
+
+func (s *typedService) Charge(ctx context.Context, in *typedReq) (*typedRes, error) {
+	switch in.Amount {
+	case 0:
+		panic("zero amount")
+	case -1:
+		return nil, common.NewCodeError(common.ErrNoPermission, "denied")
+	case -2:
+		return nil, errors.New("failed")
+	}
+	return &typedRes{Amount: in.Amount * 2}, nil
+}
+
+func (s *typedService) Refunded(ctx context.Context, in *typedReq) error {
+	s.notified += in.Amount
+	return nil
+}
+
+// 签名不符合的方法在RegisterService中忽略
+func (s *typedService) Name() string { return "typed" }
+
+func TestRegisterTyped(t *testing.T) {
+	tests := []struct {
+		name string
+		fn   interface{}
+		ok   bool
+	}{
+		{"caller", func(ctx context.Context, in *typedReq) (*typedRes, error) { return nil, nil }, true},
+		{"notifier", func(ctx context.Context, in *typedReq) error { return nil }, true},
+		{"not func", 1, false},
+		{"missing context", func(in *typedReq) error { return nil }, false},
+		{"non pointer", func(ctx context.Context, in typedReq) error { return nil }, false},
+		{"missing error", func(ctx context.Context, in *typedReq) *typedRes { return nil }, false},
+	}
+
+	for _, tt := range tests {
+		ag := NewApiGroup(nil)
+		if err := ag.RegisterTyped("f", tt.fn); (err == nil) != tt.ok {
+			t.Errorf("%s: RegisterTyped() = %v, want ok %v", tt.name, err, tt.ok)
+		}
+	}
+
+	if err := NewApiGroup(nil).RegisterService(&typedReq{}); err == nil {
+		t.Errorf("RegisterService() should fail without typed methods")
+	}
+}
+
+func TestTypedHandler(t *testing.T) {
+	s := &typedService{}
+	ag := NewApiGroup(nil)
+	ag.SetLoger(&loger.MyLoger{})
+	if err := ag.RegisterService(s); err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name  string
+		value string // 为空时编码amount
+		in    int
+		want  common.ErrCode
+		out   int
+	}{
+		{"ok", "", 2, common.ErrOk, 4},
+		{"code error", "", -1, common.ErrNoPermission, 0},
+		{"error", "", -2, common.ErrInternal, 0},
+		{"panic", "", 0, common.ErrInternal, 0},
+		{"corrupted", "not base64", 1, common.ErrDataCorrupted, 0},
+	}
+
+	for _, tt := range tests {
+		req := &common.Request{Method: common.NewMethod("v1", "pay", "charge")}
+		req.Data.SetValue(&typedReq{Amount: tt.in})
+		if tt.value != "" {
+			req.Data.Value = tt.value
+		}
+
+		res := &common.Response{}
+		ag.HandleCall(req, res)
+		if res.Data.Err != tt.want {
+			t.Errorf("%s: err = %d(%s), want %d", tt.name, res.Data.Err, res.Data.ErrMsg, tt.want)
+			continue
+		}
+		out := &typedRes{}
+		if res.Data.GetResult(out); out.Amount != tt.out {
+			t.Errorf("%s: out = %d, want %d", tt.name, out.Amount, tt.out)
+		}
+	}
+
+	req := &common.Request{Method: common.NewMethod("v1", "pay", "Refunded")}
+	req.Data.SetValue(&typedReq{Amount: 3})
+	ag.HandleNotify(req, &common.Response{})
+	if s.notified != 3 {
+		t.Errorf("notified = %d, want 3", s.notified)
+	}
+}
@@ -0,0 +1,152 @@
+package rpc
+
+import (
+	"fmt"
+	"gitlab.forceup.in/zengliang/rpc2-center/common"
+	"hash/fnv"
+	"math/rand"
+	"strconv"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+type (
+	// 负载均衡, 从候选节点中选出一个, nodes不为空
+	Balancer interface {
+		Pick(nodes []*NodeInfo, req *common.Request) *NodeInfo
+	}
+
+	roundRobinBalancer struct {
+		index uint64
+	}
+
+	randomBalancer struct {
+		mu   sync.Mutex
+		rand *rand.Rand
+	}
+
+	// 按Register.Meta["weight"]加权随机
+	weightedBalancer struct {
+		randomBalancer
+	}
+
+	// 选择正在处理请求最少的节点
+	leastInFlightBalancer struct {
+		roundRobinBalancer
+	}
+
+	// 按Request.Context[key]和节点id做一致性hash(rendezvous hashing), 没有key时轮询
+	hashBalancer struct {
+		roundRobinBalancer
+		key string
+	}
+)
+
+func NewBalancer(conf common.BalancerConfig) Balancer {
+	switch conf.Strategy {
+	case common.BalanceRandom:
+		return newRandomBalancer()
+	case common.BalanceWeighted:
+		return &weightedBalancer{randomBalancer: *newRandomBalancer()}
+	case common.BalanceLeastInFlight:
+		return &leastInFlightBalancer{}
+	case common.BalanceHash:
+		return &hashBalancer{key: conf.HashKey}
+	default:
+		return &roundRobinBalancer{}
+	}
+}
+
+func (b *roundRobinBalancer) Pick(nodes []*NodeInfo, req *common.Request) *NodeInfo {
+	index := atomic.AddUint64(&b.index, 1)
+	return nodes[index%uint64(len(nodes))]
+}
+
+func newRandomBalancer() *randomBalancer {
+	return &randomBalancer{rand: rand.New(rand.NewSource(time.Now().UnixNano()))}
+}
+
+func (b *randomBalancer) intn(n int) int {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	return b.rand.Intn(n)
+}
+
+func (b *randomBalancer) Pick(nodes []*NodeInfo, req *common.Request) *NodeInfo {
+	return nodes[b.intn(len(nodes))]
+}
+
+func (b *weightedBalancer) Pick(nodes []*NodeInfo, req *common.Request) *NodeInfo {
+	total := 0
+	weights := make([]int, len(nodes))
+	for i, node := range nodes {
+		weights[i] = node.weight()
+		total += weights[i]
+	}
+	if total <= 0 {
+		return b.randomBalancer.Pick(nodes, req)
+	}
+
+	n := b.intn(total)
+	for i, w := range weights {
+		if n < w {
+			return nodes[i]
+		}
+		n -= w
+	}
+	return nodes[len(nodes)-1]
+}
+
+func (b *leastInFlightBalancer) Pick(nodes []*NodeInfo, req *common.Request) *NodeInfo {
+	// 从轮询位置开始找, 避免负载相同时总是选第一个
+	start := int(atomic.AddUint64(&b.index, 1) % uint64(len(nodes)))
+
+	var picked *NodeInfo
+	for i := 0; i < len(nodes); i++ {
+		node := nodes[(start+i)%len(nodes)]
+		if picked == nil || node.InFlight() < picked.InFlight() {
+			picked = node
+		}
+	}
+	return picked
+}
+
+func (b *hashBalancer) Pick(nodes []*NodeInfo, req *common.Request) *NodeInfo {
+	value, ok := req.Context[b.key]
+	if b.key == "" || !ok {
+		return b.roundRobinBalancer.Pick(nodes, req)
+	}
+	key := fmt.Sprint(value)
+
+	var picked *NodeInfo
+	var max uint64
+	for _, node := range nodes {
+		h := fnv.New64a()
+		h.Write([]byte(key))
+		h.Write([]byte(node.RegisterData.Id))
+		if score := h.Sum64(); picked == nil || score > max {
+			picked, max = node, score
+		}
+	}
+	return picked
+}
+
+// 节点权重, Meta中没有配置时为1
+func (ni *NodeInfo) weight() int {
+	w, ok := ni.RegisterData.Meta[common.MetaKeyWeight]
+	if !ok {
+		return 1
+	}
+	n, err := strconv.Atoi(w)
+	if err != nil || n < 0 {
+		return 0
+	}
+	return n
+}
+
+// 节点正在处理的请求数
+func (ni *NodeInfo) InFlight() int64 {
+	return atomic.LoadInt64(&ni.inFlight)
+}
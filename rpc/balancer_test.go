@@ -0,0 +1,135 @@
+package rpc
+
+import (
+	"gitlab.forceup.in/zengliang/rpc2-center/common"
+	"testing"
+)
+
+func newTestNodes(weights ...string) []*NodeInfo {
+	nodes := []*NodeInfo{}
+	for i, weight := range weights {
+		reg := common.Register{
+			Service: common.Service{Version: "v1", Name: "pay", Tag: string(rune('a' + i))},
+			Id:      "node-" + string(rune('a'+i)),
+			Meta:    map[string]string{},
+		}
+		if weight != "" {
+			reg.Meta[common.MetaKeyWeight] = weight
+		}
+		nodes = append(nodes, &NodeInfo{RegisterData: reg})
+	}
+	return nodes
+}
+
+// 统计多次选择中每个节点被选中的次数
+func countPicks(b Balancer, nodes []*NodeInfo, req *common.Request, times int) map[*NodeInfo]int {
+	counts := make(map[*NodeInfo]int)
+	for i := 0; i < times; i++ {
+		counts[b.Pick(nodes, req)]++
+	}
+	return counts
+}
+
+func TestNodeWeight(t *testing.T) {
+	tests := []struct {
+		weight string
+		want   int
+	}{
+		{"", 1},
+		{"3", 3},
+		{"0", 0},
+		{"-1", 0},
+		{"x", 0},
+	}
+
+	for _, tt := range tests {
+		if got := newTestNodes(tt.weight)[0].weight(); got != tt.want {
+			t.Errorf("weight(%q) = %d, want %d", tt.weight, got, tt.want)
+		}
+	}
+}
+
+func TestBalancerPick(t *testing.T) {
+	req := &common.Request{}
+
+	tests := []struct {
+		name    string
+		conf    common.BalancerConfig
+		weights []string
+		check   func(nodes []*NodeInfo, counts map[*NodeInfo]int) bool
+	}{
+		{"round robin", common.BalancerConfig{}, []string{"", "", ""},
+			func(nodes []*NodeInfo, counts map[*NodeInfo]int) bool {
+				return counts[nodes[0]] == 100 && counts[nodes[1]] == 100 && counts[nodes[2]] == 100
+			}},
+		{"random", common.BalancerConfig{Strategy: common.BalanceRandom}, []string{"", ""},
+			func(nodes []*NodeInfo, counts map[*NodeInfo]int) bool {
+				return counts[nodes[0]] > 0 && counts[nodes[1]] > 0
+			}},
+		{"weighted", common.BalancerConfig{Strategy: common.BalanceWeighted}, []string{"0", "1", "9"},
+			func(nodes []*NodeInfo, counts map[*NodeInfo]int) bool {
+				return counts[nodes[0]] == 0 && counts[nodes[2]] > counts[nodes[1]]
+			}},
+		{"weighted all zero", common.BalancerConfig{Strategy: common.BalanceWeighted}, []string{"0", "0"},
+			func(nodes []*NodeInfo, counts map[*NodeInfo]int) bool {
+				return counts[nodes[0]]+counts[nodes[1]] == 300
+			}},
+		{"hash without key", common.BalancerConfig{Strategy: common.BalanceHash, HashKey: "uid"}, []string{"", ""},
+			func(nodes []*NodeInfo, counts map[*NodeInfo]int) bool {
+				return counts[nodes[0]] == 150 && counts[nodes[1]] == 150
+			}},
+	}
+
+	for _, tt := range tests {
+		nodes := newTestNodes(tt.weights...)
+		counts := countPicks(NewBalancer(tt.conf), nodes, req, 300)
+		if !tt.check(nodes, counts) {
+			t.Errorf("%s: unexpected picks %v", tt.name, counts)
+		}
+	}
+}
+
+func TestLeastInFlightBalancer(t *testing.T) {
+	nodes := newTestNodes("", "", "")
+	nodes[0].inFlight = 3
+	nodes[1].inFlight = 1
+	nodes[2].inFlight = 2
+
+	b := NewBalancer(common.BalancerConfig{Strategy: common.BalanceLeastInFlight})
+	if counts := countPicks(b, nodes, &common.Request{}, 10); counts[nodes[1]] != 10 {
+		t.Errorf("unexpected picks %v", counts)
+	}
+}
+
+// 相同的key总是选中同一个节点, 去掉其他节点后仍然选中它
+func TestHashBalancer(t *testing.T) {
+	nodes := newTestNodes("", "", "", "")
+	b := NewBalancer(common.BalancerConfig{Strategy: common.BalanceHash, HashKey: "uid"})
+
+	for _, uid := range []string{"1", "2", "3", "alice", "bob"} {
+		req := &common.Request{Context: common.Context{"uid": uid}}
+		picked := b.Pick(nodes, req)
+		if counts := countPicks(b, nodes, req, 10); counts[picked] != 10 {
+			t.Errorf("uid %s: picks are not stable %v", uid, counts)
+		}
+
+		others := []*NodeInfo{picked}
+		for _, node := range nodes {
+			if node != picked && len(others) < 3 {
+				others = append(others, node)
+			}
+		}
+		if got := b.Pick(others, req); got != picked {
+			t.Errorf("uid %s: pick changed after removing nodes", uid)
+		}
+	}
+
+	// 按节点id分散, 不同的key应该落到不同的节点
+	picked := make(map[*NodeInfo]bool)
+	for i := 0; i < 100; i++ {
+		picked[b.Pick(nodes, &common.Request{Context: common.Context{"uid": i}})] = true
+	}
+	if len(picked) != len(nodes) {
+		t.Errorf("keys are hashed to %d of %d nodes", len(picked), len(nodes))
+	}
+}
@@ -0,0 +1,168 @@
+package rpc
+
+import (
+	"fmt"
+	"gitlab.forceup.in/zengliang/rpc2-center/common"
+	"sync"
+	"time"
+)
+
+type BreakerState int
+
+const (
+	BreakerClosed   = BreakerState(0) // 正常
+	BreakerOpen     = BreakerState(1) // 熔断, 不选择该节点
+	BreakerHalfOpen = BreakerState(2) // 半开, 允许少量试探调用
+)
+
+var breakerStateStrings = map[BreakerState]string{
+	BreakerClosed:   "closed",
+	BreakerOpen:     "open",
+	BreakerHalfOpen: "half-open",
+}
+
+func (bs BreakerState) String() string {
+	if s, isOk := breakerStateStrings[bs]; isOk {
+		return s
+	}
+
+	return fmt.Sprintf("unkown state:%d", bs)
+}
+
+// 节点熔断器, 为nil或未启用时总是可用
+type CircuitBreaker struct {
+	mu sync.Mutex
+
+	conf     common.BreakerConfig
+	state    BreakerState
+	failures int
+	openedAt time.Time
+	trials   int
+}
+
+func NewCircuitBreaker(conf common.BreakerConfig) *CircuitBreaker {
+	if conf.HalfOpenMaxCalls <= 0 {
+		conf.HalfOpenMaxCalls = 1
+	}
+	return &CircuitBreaker{conf: conf}
+}
+
+func (cb *CircuitBreaker) enabled() bool {
+	return cb != nil && cb.conf.FailureThreshold > 0
+}
+
+// 是否可以选择该节点, 熔断超时后转为半开状态, 不占用半开状态的试探名额
+func (cb *CircuitBreaker) Available() bool {
+	if !cb.enabled() {
+		return true
+	}
+
+	cb.mu.Lock()
+	defer cb.mu.Unlock()
+
+	cb.checkTimeout()
+	return cb.available()
+}
+
+// 选中节点开始调用, 半开状态时占用一个试探名额, 不可用时返回false,
+// 返回true后必须调用OnResult或OnCancel
+func (cb *CircuitBreaker) Allow() bool {
+	if !cb.enabled() {
+		return true
+	}
+
+	cb.mu.Lock()
+	defer cb.mu.Unlock()
+
+	cb.checkTimeout()
+	if !cb.available() {
+		return false
+	}
+	if cb.state == BreakerHalfOpen {
+		cb.trials++
+	}
+	return true
+}
+
+// 调用方放弃了调用, 不计入结果, 只释放试探名额
+func (cb *CircuitBreaker) OnCancel() {
+	if !cb.enabled() {
+		return
+	}
+
+	cb.mu.Lock()
+	defer cb.mu.Unlock()
+
+	if cb.state == BreakerHalfOpen && cb.trials > 0 {
+		cb.trials--
+	}
+}
+
+// 调用结束, 返回状态是否发生变化
+func (cb *CircuitBreaker) OnResult(success bool) (BreakerState, bool) {
+	if !cb.enabled() {
+		return BreakerClosed, false
+	}
+
+	cb.mu.Lock()
+	defer cb.mu.Unlock()
+
+	old := cb.state
+	switch cb.state {
+	case BreakerHalfOpen:
+		if cb.trials > 0 {
+			cb.trials--
+		}
+		if success {
+			cb.state = BreakerClosed
+			cb.failures = 0
+			cb.trials = 0
+		} else {
+			cb.open()
+		}
+	case BreakerClosed:
+		if success {
+			cb.failures = 0
+		} else if cb.failures++; cb.failures >= cb.conf.FailureThreshold {
+			cb.open()
+		}
+	}
+
+	return cb.state, cb.state != old
+}
+
+func (cb *CircuitBreaker) State() BreakerState {
+	if !cb.enabled() {
+		return BreakerClosed
+	}
+
+	cb.mu.Lock()
+	defer cb.mu.Unlock()
+
+	cb.checkTimeout()
+	return cb.state
+}
+
+func (cb *CircuitBreaker) available() bool {
+	switch cb.state {
+	case BreakerOpen:
+		return false
+	case BreakerHalfOpen:
+		return cb.trials < cb.conf.HalfOpenMaxCalls
+	}
+	return true
+}
+
+func (cb *CircuitBreaker) open() {
+	cb.state = BreakerOpen
+	cb.openedAt = time.Now()
+	cb.trials = 0
+}
+
+func (cb *CircuitBreaker) checkTimeout() {
+	timeout := time.Duration(cb.conf.OpenTimeout) * time.Second
+	if cb.state == BreakerOpen && time.Since(cb.openedAt) >= timeout {
+		cb.state = BreakerHalfOpen
+		cb.trials = 0
+	}
+}
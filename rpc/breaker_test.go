@@ -0,0 +1,125 @@
+package rpc
+
+import (
+	"gitlab.forceup.in/zengliang/rpc2-center/common"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// 操作: ok/fail调用结果, allow选择节点, cancel调用方取消, expire熔断超时
+type breakerStep struct {
+	op    string
+	allow bool // op为allow时期望的返回值
+	state BreakerState
+}
+
+func runBreakerSteps(t *testing.T, name string, cb *CircuitBreaker, steps []breakerStep) {
+	for i, step := range steps {
+		switch step.op {
+		case "ok", "fail":
+			cb.OnResult(step.op == "ok")
+		case "allow":
+			if got := cb.Allow(); got != step.allow {
+				t.Errorf("%s step %d: Allow() = %v, want %v", name, i, got, step.allow)
+			}
+		case "cancel":
+			cb.OnCancel()
+		case "expire":
+			cb.mu.Lock()
+			cb.openedAt = time.Now().Add(-time.Hour)
+			cb.mu.Unlock()
+		}
+		if got := cb.State(); got != step.state {
+			t.Errorf("%s step %d(%s): state = %s, want %s", name, i, step.op, got, step.state)
+		}
+	}
+}
+
+func TestCircuitBreaker(t *testing.T) {
+	conf := common.BreakerConfig{FailureThreshold: 2, OpenTimeout: 60, HalfOpenMaxCalls: 1}
+
+	tests := []struct {
+		name  string
+		steps []breakerStep
+	}{
+		{"success resets failures", []breakerStep{
+			{op: "allow", allow: true, state: BreakerClosed},
+			{op: "fail", state: BreakerClosed},
+			{op: "ok", state: BreakerClosed},
+			{op: "fail", state: BreakerClosed},
+		}},
+		{"open after threshold", []breakerStep{
+			{op: "fail", state: BreakerClosed},
+			{op: "fail", state: BreakerOpen},
+			{op: "allow", allow: false, state: BreakerOpen},
+		}},
+		{"half-open trial succeeds", []breakerStep{
+			{op: "fail", state: BreakerClosed},
+			{op: "fail", state: BreakerOpen},
+			{op: "expire", state: BreakerHalfOpen},
+			{op: "allow", allow: true, state: BreakerHalfOpen},
+			{op: "allow", allow: false, state: BreakerHalfOpen},
+			{op: "ok", state: BreakerClosed},
+			{op: "allow", allow: true, state: BreakerClosed},
+		}},
+		{"half-open trial fails", []breakerStep{
+			{op: "fail", state: BreakerClosed},
+			{op: "fail", state: BreakerOpen},
+			{op: "expire", state: BreakerHalfOpen},
+			{op: "allow", allow: true, state: BreakerHalfOpen},
+			{op: "fail", state: BreakerOpen},
+			{op: "allow", allow: false, state: BreakerOpen},
+		}},
+		{"cancel releases trial", []breakerStep{
+			{op: "fail", state: BreakerClosed},
+			{op: "fail", state: BreakerOpen},
+			{op: "expire", state: BreakerHalfOpen},
+			{op: "allow", allow: true, state: BreakerHalfOpen},
+			{op: "cancel", state: BreakerHalfOpen},
+			{op: "allow", allow: true, state: BreakerHalfOpen},
+		}},
+	}
+
+	for _, tt := range tests {
+		runBreakerSteps(t, tt.name, NewCircuitBreaker(conf), tt.steps)
+	}
+}
+
+func TestCircuitBreakerDisabled(t *testing.T) {
+	for _, cb := range []*CircuitBreaker{nil, NewCircuitBreaker(common.BreakerConfig{})} {
+		for i := 0; i < 10; i++ {
+			cb.OnResult(false)
+		}
+		if !cb.Allow() || !cb.Available() || cb.State() != BreakerClosed {
+			t.Errorf("disabled breaker should always be available")
+		}
+	}
+}
+
+// 半开状态并发选择节点时, 占用的试探名额不能超过HalfOpenMaxCalls
+func TestCircuitBreakerConcurrentTrials(t *testing.T) {
+	cb := NewCircuitBreaker(common.BreakerConfig{FailureThreshold: 1, OpenTimeout: 60, HalfOpenMaxCalls: 2})
+	cb.OnResult(false)
+	cb.mu.Lock()
+	cb.openedAt = time.Now().Add(-time.Hour)
+	cb.mu.Unlock()
+
+	var allowed int64
+	var wg sync.WaitGroup
+	for i := 0; i < 50; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if cb.Allow() {
+				atomic.AddInt64(&allowed, 1)
+			}
+		}()
+	}
+	wg.Wait()
+
+	if allowed != 2 {
+		t.Errorf("allowed %d trials, want 2", allowed)
+	}
+}
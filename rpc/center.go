@@ -2,41 +2,76 @@ package rpc
 
 import (
 	"context"
+	"crypto/hmac"
+	"crypto/tls"
+	"crypto/x509"
 	"encoding/base64"
+	"fmt"
 	"github.com/zl03jsj/rpc2"
 	"gitlab.forceup.in/zengliang/rpc2-center/common"
 	"gitlab.forceup.in/zengliang/rpc2-center/httpserver"
 	"gitlab.forceup.in/zengliang/rpc2-center/loger"
+	"gitlab.forceup.in/zengliang/rpc2-center/metrics"
 	"gitlab.forceup.in/zengliang/rpc2-center/tools"
+	"gitlab.forceup.in/zengliang/rpc2-center/tracing"
 	"io/ioutil"
 	"net"
 	"net/http"
 	"runtime/debug"
 	"strings"
 	"sync"
+	"sync/atomic"
 	"time"
 )
 
+const stateKeyPeerCertificate = "peer_certificate"
+
 type (
 	NodeConnectStatusCallBack func(reg *common.Register, status common.ConnectStatus)
-	Center                    struct {
+
+	// 节点注册鉴权, cert为节点tls证书(没有启用tls或节点没有提供证书时为nil), 返回错误时拒绝注册
+	RegisterAuthorizer func(reg *common.Register, cert *x509.Certificate) error
+
+	Center struct {
 		loger.ILoger
 		*rpc2.Server
 
 		cfgCenter common.ConfigCenter
 		cb        NodeConnectStatusCallBack
+		authorize RegisterAuthorizer
 
 		rwMu                sync.RWMutex
 		verNameMapNodeGroup map[string]*NodeGroup
 		clientMapNodeGroup  map[*rpc2.Client]*NodeGroup
 
-		wg sync.WaitGroup
+		wg     sync.WaitGroup // 正在处理的请求
+		loopWg sync.WaitGroup // 后台routine
+
+		statusMu sync.Mutex
+		closing  bool
+		cancel   context.CancelFunc
+		listener net.Listener
 
 		apiGroup *ApiInfoGroup
 
 		httpServer *httpserver.HttpServer
 
 		regData common.Register
+
+		balancers map[string]Balancer
+
+		metrics *centerMetrics
+		tracer  *tracing.Tracer
+
+		acl     *accessControl
+		apiKeys map[string]string
+
+		id      string // 集群中的唯一id
+		nodeSeq uint64
+		peers   *peerGroup
+
+		watchers *watcherGroup
+		traffic  *trafficRouter
 	}
 )
 
@@ -46,18 +81,43 @@ func NewCenter(conf common.ConfigCenter, meta string, loger loger.ILoger, cb Nod
 		cb:                  cb,
 		verNameMapNodeGroup: make(map[string]*NodeGroup),
 		clientMapNodeGroup:  make(map[*rpc2.Client]*NodeGroup),
+		balancers:           make(map[string]Balancer),
+		acl:                 newAccessControl(conf.Acl),
+		apiKeys:             make(map[string]string),
 		apiGroup:            NewApiGroup(before),
 		httpServer:          httpserver.NewHttpServer(),
+		id:                  newCenterId(conf.Service),
+		peers:               newPeerGroup(),
+		watchers:            newWatcherGroup(),
 	}
 
 	center.regData.StartAt = tools.GetDateNowString()
 	center.regData.Meta = tools.ParseMeta(meta)
 	center.regData.Env = tools.GetOsEnv(center.cfgCenter.Env)
 	center.regData.Service = center.cfgCenter.Service
+	center.regData.Id = center.id
+
+	var err error
+	if center.traffic, err = newTrafficRouter(conf.Traffic); err != nil {
+		return nil, err
+	}
+
+	for _, apiKey := range center.cfgCenter.ApiKeys {
+		center.apiKeys[apiKey.Key] = apiKey.Name
+	}
+
+	for srvKey, conf := range center.cfgCenter.Balancer {
+		if srvKey != "*" {
+			center.balancers[strings.ToLower(srvKey)] = NewBalancer(conf)
+		}
+	}
 
 	// rpc2
 	center.Server = rpc2.NewServer()
 	center.ILoger = loger
+	center.apiGroup.SetLoger(loger)
+
+	center.metrics = newCenterMetrics(center)
 
 	return center, nil
 }
@@ -66,22 +126,153 @@ func (c *Center) GetApiGroup() *ApiInfoGroup {
 	return c.apiGroup
 }
 
+// 监控指标, 可以注册自定义指标一起通过/metrics输出
+func (c *Center) GetMetrics() *metrics.Registry {
+	return c.metrics.registry
+}
+
+// 设置服务的负载均衡策略, srvKey为version.name, "*"为默认策略
+func (c *Center) SetBalancer(srvKey string, balancer Balancer) {
+	c.rwMu.Lock()
+	defer c.rwMu.Unlock()
+
+	srvKey = strings.ToLower(srvKey)
+	c.balancers[srvKey] = balancer
+	if nodeGroup, ok := c.verNameMapNodeGroup[srvKey]; ok {
+		nodeGroup.SetBalancer(balancer)
+	}
+}
+
+// 获取服务的负载均衡策略, 需要持有rwMu
+func (c *Center) getBalancer(srvKey string) Balancer {
+	if balancer, ok := c.balancers[srvKey]; ok {
+		return balancer
+	}
+	if balancer, ok := c.balancers["*"]; ok {
+		return balancer
+	}
+	if conf, ok := c.cfgCenter.Balancer["*"]; ok {
+		return NewBalancer(conf)
+	}
+	return &roundRobinBalancer{}
+}
+
+// 设置节点注册鉴权
+func (c *Center) SetRegisterAuthorizer(authorize RegisterAuthorizer) {
+	c.authorize = authorize
+}
+
+// 设置tracer, 转发的每个请求记录一个span
+func (c *Center) SetTracer(tracer *tracing.Tracer) {
+	c.tracer = tracer
+}
+
 func StartCenter(ctx context.Context, c *Center) {
 	c.initFunction()
 
+	ctx, c.cancel = context.WithCancel(ctx)
+
 	c.startHttpServer(ctx)
 
 	c.startTcpServer(ctx)
 
+	c.startPeers(ctx)
+
 	if c.cfgCenter.KeepAlive > 0 {
 		c.startLoopKeepAlive(ctx)
 	}
 }
 
 func StopCenter(c *Center) {
-	c.wg.Wait()
+	c.Shutdown(context.Background())
+}
+
+// 停止接受新的http和rpc2连接, 通知节点center将要关闭, 等待正在处理的请求结束后关闭所有连接,
+// ctx结束时不再等待, 强制关闭并返回ctx.Err()
+func (c *Center) Shutdown(ctx context.Context) error {
+	c.statusMu.Lock()
+	c.closing = true
+	listener := c.listener
+	c.statusMu.Unlock()
+
+	c.Info("center shutting down...")
+
+	if listener != nil {
+		listener.Close()
+	}
+
+	httpDone := make(chan error, 1)
+	go func() {
+		httpDone <- c.httpServer.Shutdown(ctx)
+	}()
+
+	for _, client := range c.getClients() {
+		if err := client.Notify(common.MethodNodeClosing, c.regData.GetKey()); err != nil {
+			c.Error("notify closing err: %s", err.Error())
+		}
+	}
+
+	reqDone := make(chan struct{})
+	go func() {
+		c.wg.Wait()
+		close(reqDone)
+	}()
+
+	var err error
+	select {
+	case <-reqDone:
+		c.Info("all requests done")
+	case <-ctx.Done():
+		err = ctx.Err()
+		c.Error("shutdown %s, force close", err.Error())
+	}
+
+	if httpErr := <-httpDone; httpErr != nil {
+		c.httpServer.Stop()
+	}
+
+	for _, client := range c.getClients() {
+		c.disconnectClient(client)
+	}
+	for _, link := range c.peers.getAll() {
+		if link.addr == "" {
+			link.client.Close()
+		}
+	}
+
+	if c.cancel != nil {
+		c.cancel()
+	}
+	c.loopWg.Wait()
+
+	c.Info("center shutdown")
+	return err
+}
+
+// 开始处理一个请求, center关闭时返回false
+func (c *Center) beginRequest() bool {
+	c.statusMu.Lock()
+	defer c.statusMu.Unlock()
+
+	if c.closing {
+		return false
+	}
+	c.wg.Add(1)
+	return true
+}
 
-	c.httpServer.Stop()
+// 获取所有节点连接
+func (c *Center) getClients() []*rpc2.Client {
+	c.rwMu.RLock()
+	defer c.rwMu.RUnlock()
+
+	clients := []*rpc2.Client{}
+	for client := range c.clientMapNodeGroup {
+		if client != nil {
+			clients = append(clients, client)
+		}
+	}
+	return clients
 }
 
 func (c *Center) initFunction() {
@@ -89,11 +280,34 @@ func (c *Center) initFunction() {
 	var res string
 	c.regData.CallerList = c.apiGroup.GetCallerNameList()
 	c.regData.NotifierList = c.apiGroup.GetNotifierNameList()
+	c.regData.IdempotentList = c.apiGroup.GetIdempotentNameList()
 	c.byRegister(nil, &c.regData, &res)
 }
 
 func (c *Center) byRegister(client *rpc2.Client, reg *common.Register, res *string) error {
-	c.Info("register client %s", reg.GetInstance())
+	cert := PeerCertificate(client)
+	if cert != nil {
+		c.Info("register client %s, subject:%s", reg.GetInstance(), cert.Subject.String())
+	} else {
+		c.Info("register client %s", reg.GetInstance())
+	}
+
+	if client != nil {
+		if reg.GetKey() == c.cfgCenter.GetKey() {
+			*res = "failed"
+			c.Error("register %s denied: same as center", reg.GetInstance())
+			return common.NewCodeError(common.ErrRegisterDenied, "%s is reserved for center", reg.GetKey())
+		}
+		if err := c.checkRegister(reg, cert); err != nil {
+			*res = "failed"
+			c.Error("register %s denied: %s", reg.GetInstance(), err.Error())
+			return err
+		}
+	}
+	reg.Token = ""
+	if client != nil {
+		reg.Id = fmt.Sprintf("%s-%d", c.id, atomic.AddUint64(&c.nodeSeq, 1))
+	}
 
 	err := func() error {
 		c.rwMu.Lock()
@@ -103,7 +317,12 @@ func (c *Center) byRegister(client *rpc2.Client, reg *common.Register, res *stri
 
 		nodeGroup, ok := c.verNameMapNodeGroup[srvKey]
 		if !ok {
-			nodeGroup = &NodeGroup{ILoger: c.ILoger}
+			nodeGroup = &NodeGroup{
+				ILoger:   c.ILoger,
+				retry:    c.cfgCenter.Retry,
+				balancer: c.getBalancer(srvKey),
+				breaker:  c.cfgCenter.Breaker,
+			}
 			c.verNameMapNodeGroup[srvKey] = nodeGroup
 		}
 
@@ -122,6 +341,9 @@ func (c *Center) byRegister(client *rpc2.Client, reg *common.Register, res *stri
 	}()
 
 	if err == nil {
+		if client != nil {
+			c.publishEvent(common.EventRegister, reg)
+		}
 		if c.cb != nil {
 			c.cb(reg, common.ConnectStatusConnected)
 		}
@@ -130,6 +352,117 @@ func (c *Center) byRegister(client *rpc2.Client, reg *common.Register, res *stri
 	return err
 }
 
+// 校验节点注册的token和证书
+func (c *Center) checkRegister(reg *common.Register, cert *x509.Certificate) error {
+	auth := c.cfgCenter.RegisterAuth
+	if auth.Mode != "" {
+		secret, ok := auth.GetSecret(reg.GetKey())
+		if !ok {
+			return common.NewCodeError(common.ErrRegisterDenied, "unknown service %s", reg.GetKey())
+		}
+
+		switch auth.Mode {
+		case common.RegisterAuthStatic:
+			if !hmac.Equal([]byte(reg.Token), []byte(secret)) {
+				return common.NewCodeError(common.ErrRegisterDenied, "invalid token")
+			}
+		case common.RegisterAuthHmac:
+			if err := checkHmacToken(reg, secret, auth.MaxSkew); err != nil {
+				return err
+			}
+		default:
+			return common.NewCodeError(common.ErrRegisterDenied, "unknown auth mode %s", auth.Mode)
+		}
+	}
+
+	if c.authorize != nil {
+		if err := c.authorize(reg, cert); err != nil {
+			return common.NewCodeError(common.ErrRegisterDenied, "%s", err.Error())
+		}
+	}
+
+	return nil
+}
+
+// 校验hmac注册token, maxSkew为允许的时间误差秒数, 默认300
+func checkHmacToken(reg *common.Register, secret string, maxSkew int) error {
+	if maxSkew <= 0 {
+		maxSkew = 300
+	}
+	if skew := time.Now().Unix() - reg.Timestamp; skew > int64(maxSkew) || skew < -int64(maxSkew) {
+		return common.NewCodeError(common.ErrRegisterDenied, "token expired")
+	}
+	token := common.SignRegister(secret, reg.GetKey(), reg.Timestamp)
+	if !hmac.Equal([]byte(reg.Token), []byte(token)) {
+		return common.NewCodeError(common.ErrRegisterDenied, "invalid token")
+	}
+	return nil
+}
+
+// 启用注册鉴权时, 只有注册成功的连接可以发起调用
+func (c *Center) isAuthorizedClient(client *rpc2.Client) bool {
+	if c.cfgCenter.RegisterAuth.Mode == "" {
+		return true
+	}
+
+	c.rwMu.RLock()
+	defer c.rwMu.RUnlock()
+
+	_, ok := c.clientMapNodeGroup[client]
+	return ok
+}
+
+// rpc2连接的调用方身份, 为注册的服务key
+func (c *Center) clientCaller(client *rpc2.Client) string {
+	c.rwMu.RLock()
+	defer c.rwMu.RUnlock()
+
+	if nodeGroup, ok := c.clientMapNodeGroup[client]; ok && client != nil {
+		return nodeGroup.GetNodeInfo().GetKey()
+	}
+	return common.CallerAnonymous
+}
+
+// http请求的调用方身份, 为api key对应的名称
+func (c *Center) httpCaller(req *http.Request) string {
+	key := httpserver.ApiKeyFromRequest(req)
+
+	if name, ok := c.apiKeys[key]; ok && key != "" {
+		return common.CallerApiKeyPrefix + name
+	}
+	return common.CallerAnonymous
+}
+
+// 检查调用权限, center自己发起的调用不检查
+func (c *Center) checkPermission(ctx context.Context, req *common.Request, res *common.Response) bool {
+	caller := getCaller(req)
+	if isInternalCall(ctx) || c.acl.allowed(caller, req.Method.GetFunctionKey()) {
+		return true
+	}
+
+	c.Error("%s has no permission to %s", caller, req.Method.GetFunctionKey())
+	res.Data.Err = common.ErrNoPermission
+	res.Data.ErrMsg = fmt.Sprintf("%s has no permission to %s", caller, req.Method.GetFunctionKey())
+	return false
+}
+
+func (c *Center) byDrain(client *rpc2.Client, reg *string, res *string) error {
+	c.Info("drain client %s", *reg)
+
+	c.rwMu.RLock()
+	nodeGroup, ok := c.clientMapNodeGroup[client]
+	c.rwMu.RUnlock()
+
+	if !ok || client == nil {
+		*res = "failed"
+		return common.NewCodeError(common.ErrNotFindService, "%s not registered", *reg)
+	}
+
+	nodeGroup.Drain(client)
+	*res = "ok"
+	return nil
+}
+
 func (c *Center) byUnRegister(client *rpc2.Client, reg *string, res *string) error {
 	c.disconnectClient(client)
 	*res = "ok"
@@ -137,29 +470,65 @@ func (c *Center) byUnRegister(client *rpc2.Client, reg *string, res *string) err
 }
 
 func (c *Center) Call(req *common.Request) *common.Response {
+	return c.CallContext(context.Background(), req)
+}
+
+// 带超时/取消的调用, ctx的截止时间会随请求转发到目标节点
+func (c *Center) CallContext(ctx context.Context, req *common.Request) *common.Response {
 	var res = &common.Response{}
-	c.byCall(nil, req, res)
+	if d, ok := ctx.Deadline(); ok {
+		req.SetDeadline(d)
+	}
+
+	if !c.beginRequest() {
+		res.Data.Err = common.ErrCenterClosing
+		return res
+	}
+	defer c.wg.Done()
+
+	setCaller(req, c.cfgCenter.GetKey())
+	c.callFunction(withInternalCall(ctx), nil, req, res)
 	return res
 }
 
 func (c *Center) byCall(fromClient *rpc2.Client, req *common.Request, res *common.Response) error {
-	c.wg.Add(1)
+	if !c.beginRequest() {
+		res.Data.Err = common.ErrCenterClosing
+		return nil
+	}
 	defer c.wg.Done()
 
 	c.Debug("by call %s:%s", req.Method.GetInstance(), req.Method.Function)
 
-	c.callFunction(fromClient, req, res)
+	if !c.isAuthorizedClient(fromClient) {
+		res.Data.Err = common.ErrRegisterDenied
+		return nil
+	}
+	setCaller(req, c.clientCaller(fromClient))
+	clearHops(req)
+
+	c.callFunction(context.Background(), fromClient, req, res)
 
 	return nil
 }
 
 func (c *Center) byNotify(fromClient *rpc2.Client, req *common.Request, res *common.Response) error {
-	c.wg.Add(1)
+	if !c.beginRequest() {
+		res.Data.Err = common.ErrCenterClosing
+		return nil
+	}
 	defer c.wg.Done()
 
 	c.Debug("by notify %s:%s", req.Method.GetInstance(), req.Method.Function)
 
-	c.notifyFunction(fromClient, req, res)
+	if !c.isAuthorizedClient(fromClient) {
+		res.Data.Err = common.ErrRegisterDenied
+		return nil
+	}
+	setCaller(req, c.clientCaller(fromClient))
+	clearHops(req)
+
+	c.notifyFunction(context.Background(), fromClient, req, res)
 
 	return nil
 }
@@ -168,16 +537,93 @@ func (c *Center) startHttpServer(ctx context.Context) {
 	// http
 	c.Info("Start http server on %s", c.cfgCenter.HttpPort)
 
-	c.httpServer.RegisterHandler("/call/", http.HandlerFunc(c.handleCall))
-	c.httpServer.RegisterHandler("/notify/", http.HandlerFunc(c.handleNotify))
+	auth := httpserver.NewApiKeyAuth(c.cfgCenter.HttpAuth, c.cfgCenter.ApiKeys, c.httpService)
+
+	c.httpServer.RegisterHandler("/call/", httpserver.WithCors(auth.Wrap(c.handleCall)))
+	c.httpServer.RegisterHandler("/notify/", auth.Wrap(c.handleNotify))
+	c.httpServer.RegisterHandler("/discover", auth.Wrap(c.handleDiscover))
+	// 监控指标不需要api key, 方便prometheus等直接抓取, 其中不包含节点的地址和元数据
+	c.httpServer.RegisterHandler("/metrics", c.metrics.registry.ServeHTTP)
+	if c.cfgCenter.AdminToken != "" {
+		c.httpServer.RegisterHandler("/admin/", c.handleAdmin)
+		c.httpServer.RegisterHandler("/dashboard/", c.handleDashboard)
+		c.httpServer.RegisterHandler("/dashboard/status", c.handleDashboardStatus)
+	}
 
 	c.httpServer.Start(c.cfgCenter.HttpPort)
 }
 
+// http请求的目标服务, 用于按服务限流, 服务没有注册时返回空
+func (c *Center) httpService(req *http.Request) string {
+	if !strings.HasPrefix(req.URL.Path, "/call/") && !strings.HasPrefix(req.URL.Path, "/notify/") {
+		return ""
+	}
+
+	reqData := &common.Request{}
+	reqData.Method.FromPath(req.URL.Path)
+	c.resolveRequestVersion(reqData)
+	if srvKey := reqData.Method.GetKey(); c.hasService(srvKey) {
+		return srvKey
+	}
+	return ""
+}
+
+// 服务是否注册在本center或peer上
+func (c *Center) hasService(srvKey string) bool {
+	c.rwMu.RLock()
+	_, ok := c.verNameMapNodeGroup[srvKey]
+	c.rwMu.RUnlock()
+	if ok {
+		return true
+	}
+
+	for _, link := range c.peers.getAll() {
+		if link.hasService(srvKey, nil) {
+			return true
+		}
+	}
+	return false
+}
+
+// 函数是否注册在本center或peer上, kind为call或notify
+func (c *Center) hasFunction(kind string, method *common.Method) bool {
+	srvKey := method.GetKey()
+	notify := kind == metricTypeNotify
+	if srvKey == c.cfgCenter.GetKey() {
+		return c.apiGroup.hasFunction(notify, method.Function)
+	}
+
+	c.rwMu.RLock()
+	nodeGroup, ok := c.verNameMapNodeGroup[srvKey]
+	c.rwMu.RUnlock()
+	if ok && nodeGroup.hasFunction(notify, method.Function) {
+		return true
+	}
+
+	for _, link := range c.peers.getAll() {
+		for _, reg := range link.getNodes() {
+			if reg.GetKey() != srvKey {
+				continue
+			}
+			functions := reg.CallerList
+			if notify {
+				functions = reg.NotifierList
+			}
+			for _, function := range functions {
+				if strings.EqualFold(function, method.Function) {
+					return true
+				}
+			}
+		}
+	}
+	return false
+}
+
 func (c *Center) disconnectClient(client *rpc2.Client) {
 	if client != nil {
 		client.Close()
 	}
+	c.watchers.remove(client)
 
 	reg := func() *common.Register {
 		c.rwMu.Lock()
@@ -202,6 +648,7 @@ func (c *Center) disconnectClient(client *rpc2.Client) {
 	}()
 
 	if reg != nil {
+		c.publishEvent(common.EventUnRegister, reg)
 		if c.cb != nil {
 			c.cb(reg, common.ConnectStatusDisConnected)
 		}
@@ -217,12 +664,20 @@ func (c *Center) startTcpServer(ctx context.Context) {
 		c.Info("rpc2 client disconnect...")
 
 		c.disconnectClient(client)
+		c.removePeer(client)
 	})
 
 	c.Server.Handle(common.MethodCenterRegister, c.byRegister)
 	c.Server.Handle(common.MethodCenterUnRegister, c.byUnRegister)
+	c.Server.Handle(common.MethodCenterDrain, c.byDrain)
+	c.Server.Handle(common.MethodCenterWatch, c.byWatch)
+	c.Server.Handle(common.MethodCenterDiscover, c.byDiscover)
 	c.Server.Handle(common.MethodCenterCall, c.byCall)
 	c.Server.Handle(common.MethodCenterNotify, c.byNotify)
+	c.Server.Handle(common.MethodCenterPeerJoin, c.byPeerJoin)
+	c.Server.Handle(common.MethodCenterPeerEvent, c.byPeerEvent)
+	c.Server.Handle(common.MethodCenterPeerCall, c.byPeerCall)
+	c.Server.Handle(common.MethodCenterPeerNotify, c.byPeerNotify)
 
 	c.Info("Start RPC Tcp server on %s", c.cfgCenter.RpcPort)
 
@@ -230,33 +685,99 @@ func (c *Center) startTcpServer(ctx context.Context) {
 	if err != nil {
 		c.Fatal("%s", err)
 	}
-	listener, err := net.ListenTCP("tcp", addr)
+	var listener net.Listener
+	listener, err = net.ListenTCP("tcp", addr)
 	if err != nil {
 		c.Fatal("%s", err)
 	}
 
+	if c.cfgCenter.TLS.Enable {
+		tlsConf, err := c.cfgCenter.TLS.ServerConfig()
+		if err != nil {
+			c.Fatal("%s", err)
+		}
+		listener = tls.NewListener(listener, tlsConf)
+	}
+
+	c.statusMu.Lock()
+	c.listener = listener
+	c.statusMu.Unlock()
+
+	c.loopWg.Add(1)
 	go func() {
-		c.wg.Add(1)
-		defer c.wg.Done()
+		defer c.loopWg.Done()
 
 		c.Info("Tcp server routine running... ")
 
-		go c.Server.Accept(listener)
+		go c.accept(listener)
 		<-ctx.Done()
+		listener.Close()
 
 		c.Info("Tcp server routine stopped... ")
 	}()
 }
 
+// 接受rpc2连接, tls连接在这里完成握手
+func (c *Center) accept(listener net.Listener) {
+	for {
+		conn, err := listener.Accept()
+		if err != nil {
+			if ne, ok := err.(net.Error); ok && ne.Temporary() {
+				c.Error("rpc2 accept: %s", err.Error())
+				time.Sleep(time.Millisecond * 100)
+				continue
+			}
+			c.Info("rpc2 accept stopped: %s", err.Error())
+			return
+		}
+
+		go c.serveConn(conn)
+	}
+}
+
+func (c *Center) serveConn(conn net.Conn) {
+	state := rpc2.NewState()
+
+	if tlsConn, ok := conn.(*tls.Conn); ok {
+		tlsConn.SetDeadline(time.Now().Add(time.Second * 10))
+		if err := tlsConn.Handshake(); err != nil {
+			c.Error("tls handshake with %s: %s", conn.RemoteAddr().String(), err.Error())
+			conn.Close()
+			return
+		}
+		tlsConn.SetDeadline(time.Time{})
+
+		if certs := tlsConn.ConnectionState().PeerCertificates; len(certs) > 0 {
+			state.Set(stateKeyPeerCertificate, certs[0])
+		}
+	}
+
+	c.Server.ServeCodecWithState(rpc2.NewGobCodec(conn), state)
+}
+
+// 获取节点连接使用的已校验的tls证书
+func PeerCertificate(client *rpc2.Client) *x509.Certificate {
+	if client == nil || client.State == nil {
+		return nil
+	}
+
+	v, ok := client.State.Get(stateKeyPeerCertificate)
+	if !ok {
+		return nil
+	}
+	cert, _ := v.(*x509.Certificate)
+	return cert
+}
+
 func (c *Center) startLoopKeepAlive(ctx context.Context) {
 	c.Trace("start keep alive loop...")
 
+	c.loopWg.Add(1)
 	go func() {
-		c.wg.Add(1)
 		c.Trace("keep alive loop running...")
 
 		defer func() {
-			c.wg.Done()
+			c.loopWg.Done()
 			c.Trace("keep alive loop exit...")
 		}()
 
@@ -264,8 +785,7 @@ func (c *Center) startLoopKeepAlive(ctx context.Context) {
 			select {
 			case <-ctx.Done():
 				return
-			default:
-				time.Sleep(time.Duration(c.cfgCenter.KeepAlive) * time.Second)
+			case <-time.After(time.Duration(c.cfgCenter.KeepAlive) * time.Second):
 			}
 
 			c.Trace("doing keep alive...")
@@ -285,7 +805,7 @@ func (c *Center) callKeepAlive() []*rpc2.Client {
 	defer c.rwMu.RUnlock()
 
 	cc := []*rpc2.Client{}
-	for client, _ := range c.clientMapNodeGroup {
+	for client, nodeGroup := range c.clientMapNodeGroup {
 		if client == nil {
 			continue
 		}
@@ -293,10 +813,12 @@ func (c *Center) callKeepAlive() []*rpc2.Client {
 		res := ""
 		err := client.Call(common.MethodNodeKeepAlive, "ping", &res)
 		if err == nil && res == "pong" {
+			nodeGroup.KeepAlive(client)
 			continue
 		}
 
 		cc = append(cc, client)
+		c.metrics.keepAliveFailures.Inc(nodeGroup.GetNodeInfo().GetKey())
 		if err != nil {
 			c.Error("keepalive err: %s", err.Error())
 			continue
@@ -311,19 +833,61 @@ func (c *Center) callKeepAlive() []*rpc2.Client {
 }
 
 //  call a srv node
-func (c *Center) callFunction(fromClient *rpc2.Client, req *common.Request, res *common.Response) {
+func (c *Center) callFunction(ctx context.Context, fromClient *rpc2.Client, req *common.Request, res *common.Response) {
+	// peer转发来的请求已经在第一个center上按流量规则处理过
+	if len(getHops(req)) == 0 {
+		c.traffic.route(req)
+	}
+	c.resolveRequestVersion(req)
 	srvKey := strings.ToLower(req.Method.GetKey())
 	c.Trace("call %s:%s", req.Method.GetInstance(), req.Method.Function)
 	defer c.Trace("call %s:%s ret=%d",
 		req.Method.GetInstance(), req.Method.Function, res.Data.Err)
+	defer c.metrics.begin(metricTypeCall, req, res)()
+
+	span, finish := startRequestSpan(c.tracer, metricTypeCall, tracing.Extract(req), req, res)
+	defer finish()
+
+	ctx, cancel := req.WithDeadline(ctx)
+	defer cancel()
+	if ctx.Err() != nil {
+		res.Data.Err = common.ContextErrCode(ctx.Err())
+		res.Data.ErrMsg = ctx.Err().Error()
+		return
+	}
+	req.SetCtx(tracing.ContextWithSpan(ctx, span))
 
+	if !c.checkPermission(ctx, req, res) {
+		return
+	}
+	selector, ok := checkSelector(req, res)
+	if !ok {
+		return
+	}
+
+	if c.callLocal(ctx, fromClient, srvKey, selector, req, res) || c.forwardCall(ctx, selector, req, res) {
+		setServedVersion(res, req.Method.Version)
+		return
+	}
+
+	res.Data.Err = common.ErrNotFindService
+	res.Data.ErrMsg = "ErrNotFindService"
+	return
+}
+
+// 调用center自己或注册在本center上的服务, 没有找到服务时返回false
+func (c *Center) callLocal(ctx context.Context, fromClient *rpc2.Client, srvKey string,
+	selector labelSelector, req *common.Request, res *common.Response) bool {
+	// 只在查找节点组时加锁, 调用节点可能因为重试持续较长时间, 不能阻塞节点的注册和注销
 	c.rwMu.RLock()
-	defer c.rwMu.RUnlock()
+	apiGroup := c.apiGroup
+	srvNodeGroup, ok := c.verNameMapNodeGroup[srvKey]
+	c.rwMu.RUnlock()
 
 	if srvKey == c.cfgCenter.GetKey() {
-		if c.apiGroup == nil {
+		if apiGroup == nil {
 			res.Data.Err = common.ErrInternal
-			return
+			return true
 		}
 		defer func() {
 			if err := recover(); err != nil {
@@ -331,62 +895,88 @@ func (c *Center) callFunction(fromClient *rpc2.Client, req *common.Request, res
 				c.Error(string(debug.Stack()))
 			}
 		}()
-		c.apiGroup.HandleCall(req, res)
-		return
+		apiGroup.HandleCall(req, res)
+		return true
 	}
 
-	if srvNodeGroup, ok := c.verNameMapNodeGroup[srvKey]; ok {
-		srvNodeGroup.Call(fromClient, req, res)
-		return
+	if ok {
+		srvNodeGroup.callContext(ctx, fromClient, selector, req, res)
+		return true
 	}
 
-	res.Data.Err = common.ErrNotFindService
-	res.Data.ErrMsg = "ErrNotFindService"
-	return
+	return false
 }
 
 //  notify a srv node
-func (c *Center) notifyFunction(fromClient *rpc2.Client, req *common.Request, res *common.Response) {
+func (c *Center) notifyFunction(ctx context.Context, fromClient *rpc2.Client, req *common.Request, res *common.Response) {
+	c.resolveRequestVersion(req)
 	srvKey := strings.ToLower(req.Method.GetKey())
 	c.Trace("notify %s:%s", req.Method.GetInstance(), req.Method.Function)
 	defer c.Trace("notify %s:%s ret=%d", req.Method.GetInstance(), req.Method.Function, res.Data.Err)
+	defer c.metrics.begin(metricTypeNotify, req, res)()
+
+	span, finish := startRequestSpan(c.tracer, metricTypeNotify, tracing.Extract(req), req, res)
+	defer finish()
+	req.SetCtx(tracing.ContextWithSpan(ctx, span))
+
+	if !c.checkPermission(ctx, req, res) {
+		return
+	}
+	selector, ok := checkSelector(req, res)
+	if !ok {
+		return
+	}
+
+	if c.notifyLocal(fromClient, srvKey, selector, req, res) || c.forwardNotify(selector, req, res) {
+		setServedVersion(res, req.Method.Version)
+		return
+	}
+
+	res.Data.Err = common.ErrNotFindService
+	return
+}
 
+// 通知center自己或注册在本center上的服务, 没有找到服务时返回false
+func (c *Center) notifyLocal(fromClient *rpc2.Client, srvKey string, selector labelSelector,
+	req *common.Request, res *common.Response) bool {
 	c.rwMu.RLock()
 	defer c.rwMu.RUnlock()
 
 	if srvKey == c.cfgCenter.GetKey() {
 		if c.apiGroup == nil {
 			res.Data.Err = common.ErrInternal
-			return
+			return true
 		}
 
 		c.apiGroup.HandleNotify(req, res)
-		return
+		return true
 	}
 
 	if srvNodeGroup, ok := c.verNameMapNodeGroup[srvKey]; ok {
-		srvNodeGroup.Notify(fromClient, req, res)
-		return
+		srvNodeGroup.notify(fromClient, selector, req, res)
+		return true
 	}
 
-	res.Data.Err = common.ErrNotFindService
-	return
+	return false
 }
 
 func (c *Center) handleCall(w http.ResponseWriter, req *http.Request) {
 	c.Trace("Http server Accept a call client: %s", req.RemoteAddr)
 	defer req.Body.Close()
 
-	w.Header().Set("Access-Control-Allow-Origin", "*")             //允许访问所有域
-	w.Header().Add("Access-Control-Allow-Headers", "Content-Type") //header的类型
-
-	c.wg.Add(1)
+	if !c.beginRequest() {
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusServiceUnavailable)
+		httpserver.ResponseDataByIndent(w, common.HttpUserResponse{Err: common.ErrCenterClosing})
+		return
+	}
 	defer c.wg.Done()
 
 	userResponse := common.HttpUserResponse{}
 	func() {
-		reqData := common.Request{}
+		reqData := common.Request{Context: httpRequestContext(req)}
 		reqData.Method.FromPath(req.URL.Path)
+		setCaller(&reqData, c.httpCaller(req))
 		reqData.Method.Tag = req.URL.Query().Get("tag")
 
 		// get argv
@@ -400,7 +990,10 @@ func (c *Center) handleCall(w http.ResponseWriter, req *http.Request) {
 		reqData.Data.Value = base64.StdEncoding.EncodeToString(b)
 
 		resData := common.Response{}
-		c.callFunction(nil, &reqData, &resData)
+		c.callFunction(req.Context(), nil, &reqData, &resData)
+		if version, ok := resData.Context[common.ContextKeyServedVersion].(string); ok {
+			w.Header().Set(common.HeaderServedVersion, version)
+		}
 
 		if resData.Data.Err != common.ErrOk {
 			c.Error("call http handler: %d", resData.Data.Err)
@@ -438,14 +1031,20 @@ func (c *Center) handleNotify(w http.ResponseWriter, req *http.Request) {
 	//w.Header().Set("Access-Control-Allow-Origin", "*")             //允许访问所有域
 	//w.Header().Add("Access-Control-Allow-Headers", "Content-Type") //header的类型
 
-	c.wg.Add(1)
+	if !c.beginRequest() {
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusServiceUnavailable)
+		httpserver.ResponseDataByIndent(w, common.UserResponse{Err: common.ErrCenterClosing})
+		return
+	}
 	defer c.wg.Done()
 
 	userResponse := common.UserResponse{}
 	func() {
 		//fmt.Println("path=", req.URL.Path)
-		reqData := common.Request{}
+		reqData := common.Request{Context: httpRequestContext(req)}
 		reqData.Method.FromPath(req.URL.Path)
+		setCaller(&reqData, c.httpCaller(req))
 		reqData.Method.Tag = req.URL.Query().Get("tag")
 
 		// get argv
@@ -459,7 +1058,7 @@ func (c *Center) handleNotify(w http.ResponseWriter, req *http.Request) {
 		reqData.Data.Value = base64.StdEncoding.EncodeToString(b)
 
 		resData := common.Response{}
-		c.notifyFunction(nil, &reqData, &resData)
+		c.notifyFunction(context.Background(), nil, &reqData, &resData)
 
 		if resData.Data.Err != common.ErrOk {
 			c.Error("notify http handler: %d", resData.Data.Err)
@@ -489,13 +1088,13 @@ func (c *Center) handleNotify(w http.ResponseWriter, req *http.Request) {
 	return
 }
 
-func (c *Center) ListSrv() map[string][]common.Register {
+func (c *Center) ListSrv() map[string][]common.NodeStatus {
 	c.rwMu.RLock()
 	defer c.rwMu.RUnlock()
 
-	srvInfoList := make(map[string][]common.Register)
+	srvInfoList := make(map[string][]common.NodeStatus)
 	for srvKey, v := range c.verNameMapNodeGroup {
-		srvInfoNodes := v.GetNodes()
+		srvInfoNodes := v.GetNodeStatus()
 
 		srvInfoList[srvKey] = srvInfoNodes
 	}
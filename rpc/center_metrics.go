@@ -0,0 +1,73 @@
+package rpc
+
+import (
+	"gitlab.forceup.in/zengliang/rpc2-center/common"
+	"gitlab.forceup.in/zengliang/rpc2-center/metrics"
+	"strconv"
+	"time"
+)
+
+const (
+	metricTypeCall   = "call"
+	metricTypeNotify = "notify"
+
+	// 没有注册的服务或函数, 避免任意路径产生新的指标
+	metricMethodUnknown = "unknown"
+)
+
+// center的监控指标, 通过http /metrics输出
+type centerMetrics struct {
+	registry *metrics.Registry
+	center   *Center
+
+	requests          *metrics.CounterVec
+	duration          *metrics.HistogramVec
+	inFlight          *metrics.GaugeVec
+	keepAliveFailures *metrics.CounterVec
+}
+
+func newCenterMetrics(c *Center) *centerMetrics {
+	registry := metrics.NewRegistry()
+
+	m := &centerMetrics{
+		registry: registry,
+		center:   c,
+		requests: registry.NewCounter("rpc_center_requests_total",
+			"Requests handled by the center.", "type", "method", "code"),
+		duration: registry.NewHistogram("rpc_center_request_duration_seconds",
+			"Request latency in seconds.", nil, "type", "method"),
+		inFlight: registry.NewGauge("rpc_center_in_flight_requests",
+			"Requests currently being handled by the center."),
+		keepAliveFailures: registry.NewCounter("rpc_center_keepalive_failures_total",
+			"Keepalive failures per service.", "service"),
+	}
+
+	registry.NewGaugeFunc("rpc_center_nodes", "Registered nodes per service.", []string{"service"},
+		func(set func(value float64, labelValues ...string)) {
+			c.rwMu.RLock()
+			defer c.rwMu.RUnlock()
+
+			for srvKey, nodeGroup := range c.verNameMapNodeGroup {
+				set(float64(nodeGroup.GetNodeCount()), srvKey)
+			}
+		})
+
+	return m
+}
+
+// 开始处理请求, 返回的函数在请求结束时调用
+func (m *centerMetrics) begin(kind string, req *common.Request, res *common.Response) func() {
+	start := time.Now()
+	m.inFlight.Inc()
+
+	return func() {
+		method := metricMethodUnknown
+		if m.center.hasFunction(kind, &req.Method) {
+			method = req.Method.GetFunctionKey()
+		}
+
+		m.inFlight.Dec()
+		m.requests.Inc(kind, method, strconv.FormatInt(int64(res.Data.Err), 10))
+		m.duration.Observe(time.Since(start).Seconds(), kind, method)
+	}
+}
@@ -0,0 +1,95 @@
+package rpc
+
+import (
+	"context"
+	"gitlab.forceup.in/zengliang/rpc2-center/common"
+	"gitlab.forceup.in/zengliang/rpc2-center/loger"
+	"testing"
+	"time"
+)
+
+func TestCheckHmacToken(t *testing.T) {
+	now := time.Now().Unix()
+
+	tests := []struct {
+		name      string
+		secret    string
+		timestamp int64
+		maxSkew   int
+		ok        bool
+	}{
+		{"valid", "s", now, 0, true},
+		{"wrong secret", "x", now, 0, false},
+		{"default skew", "s", now - 301, 0, false},
+		{"in skew", "s", now - 50, 60, true},
+		{"expired", "s", now - 61, 60, false},
+		{"future", "s", now + 61, 60, false},
+	}
+
+	for _, tt := range tests {
+		reg := &common.Register{Service: common.Service{Version: "v1", Name: "pay"}, Timestamp: tt.timestamp}
+		reg.Token = common.SignRegister(tt.secret, reg.GetKey(), reg.Timestamp)
+
+		err := checkHmacToken(reg, "s", tt.maxSkew)
+		if (err == nil) != tt.ok {
+			t.Errorf("%s: checkHmacToken() = %v, want ok %v", tt.name, err, tt.ok)
+		}
+	}
+}
+
+func newTestCenter(t *testing.T) *Center {
+	conf := common.ConfigCenter{Service: common.Service{Version: "v1", Name: "center"}}
+	c, err := NewCenter(conf, "", &loger.MyLoger{}, nil, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	c.httpServer.Start("127.0.0.1:0")
+	return c
+}
+
+// 关闭时不再接受新请求, 等待正在处理的请求结束后返回
+func TestCenterShutdown(t *testing.T) {
+	c := newTestCenter(t)
+	if !c.beginRequest() {
+		t.Fatalf("beginRequest() = false before shutdown")
+	}
+
+	done := make(chan error, 1)
+	go func() {
+		done <- c.Shutdown(context.Background())
+	}()
+
+	select {
+	case err := <-done:
+		t.Fatalf("Shutdown() = %v before request done", err)
+	case <-time.After(time.Millisecond * 100):
+	}
+	if c.beginRequest() {
+		t.Errorf("beginRequest() = true while shutting down")
+	}
+
+	c.wg.Done()
+	select {
+	case err := <-done:
+		if err != nil {
+			t.Errorf("Shutdown() = %v", err)
+		}
+	case <-time.After(time.Second):
+		t.Fatalf("Shutdown() not return after request done")
+	}
+}
+
+// ctx结束时不再等待正在处理的请求
+func TestCenterShutdownTimeout(t *testing.T) {
+	c := newTestCenter(t)
+	if !c.beginRequest() {
+		t.Fatalf("beginRequest() = false before shutdown")
+	}
+	defer c.wg.Done()
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Millisecond*100)
+	defer cancel()
+	if err := c.Shutdown(ctx); err != context.DeadlineExceeded {
+		t.Errorf("Shutdown() = %v, want %v", err, context.DeadlineExceeded)
+	}
+}
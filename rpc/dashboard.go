@@ -0,0 +1,230 @@
+package rpc
+
+import (
+	"gitlab.forceup.in/zengliang/rpc2-center/common"
+	"gitlab.forceup.in/zengliang/rpc2-center/httpserver"
+	"net/http"
+	"sort"
+	"time"
+)
+
+type (
+	// 按函数统计的请求数, 都是累计值, 速率由页面按两次刷新的差值计算
+	dashboardFunction struct {
+		Type   string `json:"type"`
+		Method string `json:"method"`
+		Calls  int64  `json:"calls"`
+		Errors int64  `json:"errors"`
+	}
+
+	dashboardStatus struct {
+		Time      int64                      `json:"time"`
+		Center    common.Register            `json:"center"`
+		KeepAlive int                        `json:"keepalive"` // 心跳间隔(秒), 0表示不检查心跳
+		Nodes     []common.NodeStatus        `json:"nodes"`
+		Functions []dashboardFunction        `json:"functions"`
+		Traffic   []common.TrafficRuleStatus `json:"traffic"`
+	}
+)
+
+// 状态页面, 数据由页面定时从/dashboard/status获取
+func (c *Center) handleDashboard(w http.ResponseWriter, req *http.Request) {
+	if req.URL.Path != "/dashboard/" {
+		http.NotFound(w, req)
+		return
+	}
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	w.Write([]byte(dashboardHtml))
+}
+
+// 与管理接口一样需要Authorization: Bearer {admin_token}
+func (c *Center) handleDashboardStatus(w http.ResponseWriter, req *http.Request) {
+	if !c.isAdmin(req) {
+		httpserver.ResponseError(w, http.StatusUnauthorized, common.ErrUnauthorized, "invalid admin token")
+		return
+	}
+
+	c.responseAdmin(w, c.getDashboardStatus())
+}
+
+func (c *Center) getDashboardStatus() *dashboardStatus {
+	return &dashboardStatus{
+		Time:      time.Now().Unix(),
+		Center:    c.regData,
+		KeepAlive: c.cfgCenter.KeepAlive,
+		Nodes:     c.GetNodes(""),
+		Functions: c.functionStats(),
+		Traffic:   c.GetTrafficRules(),
+	}
+}
+
+// 从请求计数器汇总每个函数的调用次数和失败次数, 错误码不为0的都算失败
+func (c *Center) functionStats() []dashboardFunction {
+	stats := map[[2]string]*dashboardFunction{}
+	c.metrics.requests.Each(func(value float64, labelValues ...string) {
+		kind, method, code := labelValues[0], labelValues[1], labelValues[2]
+
+		key := [2]string{kind, method}
+		stat, ok := stats[key]
+		if !ok {
+			stat = &dashboardFunction{Type: kind, Method: method}
+			stats[key] = stat
+		}
+		stat.Calls += int64(value)
+		if code != "0" {
+			stat.Errors += int64(value)
+		}
+	})
+
+	functions := make([]dashboardFunction, 0, len(stats))
+	for _, stat := range stats {
+		functions = append(functions, *stat)
+	}
+	sort.Slice(functions, func(i, j int) bool {
+		if functions[i].Method != functions[j].Method {
+			return functions[i].Method < functions[j].Method
+		}
+		return functions[i].Type < functions[j].Type
+	})
+	return functions
+}
+
+const dashboardHtml = `<!DOCTYPE html>
+<html>
+<head>
+<meta charset="utf-8">
+<title>rpc center</title>
+<style>
+body { font-family: sans-serif; font-size: 13px; margin: 16px; color: #222; }
+h1 { font-size: 18px; } h2 { font-size: 15px; margin-top: 24px; }
+table { border-collapse: collapse; width: 100%; }
+th, td { border: 1px solid #ddd; padding: 4px 8px; text-align: left; vertical-align: top; }
+th { background: #f4f4f4; }
+.ok { color: #1a7f37; } .warn { color: #bf8700; } .bad { color: #cf222e; }
+.muted { color: #888; } #error { color: #cf222e; }
+</style>
+</head>
+<body>
+<h1>rpc center <span id="center" class="muted"></span></h1>
+<div id="error"></div>
+<h2>Services</h2>
+<table><thead><tr><th>service</th><th>instances</th><th>healthy</th><th>calls/s</th><th>errors/s</th></tr></thead><tbody id="services"></tbody></table>
+<h2>Instances</h2>
+<table><thead><tr><th>id</th><th>service</th><th>tag</th><th>meta</th><th>env</th><th>state</th><th>breaker</th><th>in flight</th><th>calls</th><th>failures</th><th>uptime</th><th>keepalive</th></tr></thead><tbody id="instances"></tbody></table>
+<h2>Functions</h2>
+<table><thead><tr><th>function</th><th>type</th><th>calls/s</th><th>errors/s</th><th>error rate</th><th>total calls</th><th>total errors</th></tr></thead><tbody id="functions"></tbody></table>
+<h2>Traffic rules</h2>
+<table><thead><tr><th>name</th><th>service</th><th>match</th><th>targets</th><th>hits</th></tr></thead><tbody id="traffic"></tbody></table>
+<script>
+var interval = 3000, last = null;
+
+function token() {
+	var t = location.hash.replace(/^#token=/, "");
+	if (t && t !== location.hash) { sessionStorage.setItem("rpc_admin_token", t); history.replaceState(null, "", location.pathname); }
+	return sessionStorage.getItem("rpc_admin_token") || "";
+}
+
+function esc(s) {
+	return String(s === undefined || s === null ? "" : s).replace(/[&<>"]/g, function (c) {
+		return { "&": "&amp;", "<": "&lt;", ">": "&gt;", '"': "&quot;" }[c];
+	});
+}
+
+function kv(m) {
+	return Object.keys(m || {}).sort().map(function (k) { return esc(k) + "=" + esc(m[k]); }).join("<br>");
+}
+
+function rate(cur, prev, seconds) {
+	return seconds > 0 && prev !== undefined ? Math.max(cur - prev, 0) / seconds : 0;
+}
+
+function fmtRate(v) { return v.toFixed(2); }
+
+function fmtDuration(s) {
+	if (s < 60) return s + "s";
+	if (s < 3600) return Math.floor(s / 60) + "m";
+	if (s < 86400) return Math.floor(s / 3600) + "h" + Math.floor(s % 3600 / 60) + "m";
+	return Math.floor(s / 86400) + "d" + Math.floor(s % 86400 / 3600) + "h";
+}
+
+function keepalive(st, node) {
+	var age = st.time - node.keepalive_at;
+	if (!st.keepalive) return { ok: true, html: '<span class="muted">off</span>' };
+	if (!node.keepalive_at) return { ok: true, html: '<span class="muted">n/a</span>' };
+	var cls = age <= st.keepalive * 2 ? "ok" : age <= st.keepalive * 3 ? "warn" : "bad";
+	return { ok: cls === "ok", html: '<span class="' + cls + '">' + age + "s ago</span>" };
+}
+
+function render(st) {
+	var seconds = last ? st.time - last.time : 0, prev = {}, srvs = {};
+	if (last) last.functions.forEach(function (f) { prev[f.type + " " + f.method] = f; });
+
+	document.getElementById("center").textContent = st.center.version + "." + st.center.name + " " + (st.center.id || "");
+
+	document.getElementById("instances").innerHTML = st.nodes.map(function (n) {
+		var key = (n.version + "." + n.name).toLowerCase(), ka = keepalive(st, n);
+		var srv = srvs[key] = srvs[key] || { instances: 0, healthy: 0, calls: 0, errors: 0 };
+		var state = n.draining ? '<span class="warn">draining</span>' : n.cordoned ? '<span class="warn">cordoned</span>' : '<span class="ok">active</span>';
+		srv.instances++;
+		if (ka.ok && !n.draining && !n.cordoned && n.breaker !== "open") srv.healthy++;
+		return "<tr><td>" + esc(n.id) + "</td><td>" + esc(key) + "</td><td>" + esc(n.tag) + "</td><td>" + kv(n.meta) + "</td><td>" + kv(n.env) +
+			"</td><td>" + state + "</td><td>" + esc(n.breaker) + "</td><td>" + n.in_flight + "</td><td>" + n.calls + "</td><td>" + n.failures +
+			"</td><td>" + fmtDuration(n.uptime) + "</td><td>" + ka.html + "</td></tr>";
+	}).join("");
+
+	document.getElementById("functions").innerHTML = st.functions.map(function (f) {
+		var p = prev[f.type + " " + f.method] || {}, calls = rate(f.calls, p.calls, seconds), errors = rate(f.errors, p.errors, seconds);
+		var key = f.method.split(".").slice(0, 2).join(".");
+		if (srvs[key]) { srvs[key].calls += calls; srvs[key].errors += errors; }
+		var errRate = calls > 0 ? errors / calls : (f.calls > 0 ? f.errors / f.calls : 0);
+		var cls = errRate === 0 ? "ok" : errRate < 0.05 ? "warn" : "bad";
+		return "<tr><td>" + esc(f.method) + "</td><td>" + esc(f.type) + "</td><td>" + fmtRate(calls) + "</td><td>" + fmtRate(errors) +
+			'</td><td class="' + cls + '">' + (errRate * 100).toFixed(1) + "%</td><td>" + f.calls + "</td><td>" + f.errors + "</td></tr>";
+	}).join("");
+
+	document.getElementById("services").innerHTML = Object.keys(srvs).sort().map(function (k) {
+		var s = srvs[k], cls = s.healthy === s.instances ? "ok" : s.healthy > 0 ? "warn" : "bad";
+		return "<tr><td>" + esc(k) + "</td><td>" + s.instances + '</td><td class="' + cls + '">' + s.healthy + "</td><td>" +
+			fmtRate(s.calls) + "</td><td>" + fmtRate(s.errors) + "</td></tr>";
+	}).join("");
+
+	document.getElementById("traffic").innerHTML = (st.traffic || []).map(function (r) {
+		var targets = (r.targets || []).map(function (t, i) {
+			return esc(t.version) + (t.tag ? "(" + esc(t.tag) + ")" : "") + " w=" + t.weight + " hits=" + (r.target_hits || [])[i];
+		}).join("<br>");
+		var match = r.match_key ? esc(r.match_key) + " in (" + esc((r.match_values || []).join(",")) + ")" : "*";
+		return "<tr><td>" + esc(r.name) + "</td><td>" + esc(r.service) + "</td><td>" + match + "</td><td>" + targets + "</td><td>" + r.hits + "</td></tr>";
+	}).join("");
+
+	last = st;
+}
+
+function refresh() {
+	var xhr = new XMLHttpRequest();
+	xhr.open("GET", "/dashboard/status");
+	var t = token();
+	if (t) xhr.setRequestHeader("Authorization", "Bearer " + t);
+	xhr.onload = function () {
+		if (xhr.status === 401) {
+			sessionStorage.removeItem("rpc_admin_token");
+			var input = prompt("admin token");
+			if (input) { sessionStorage.setItem("rpc_admin_token", input); refresh(); }
+			return;
+		}
+		try {
+			render(JSON.parse(xhr.responseText).result);
+			document.getElementById("error").textContent = "";
+		} catch (e) {
+			document.getElementById("error").textContent = "invalid status: " + e;
+		}
+	};
+	xhr.onerror = function () { document.getElementById("error").textContent = "center unreachable"; };
+	xhr.send();
+}
+
+refresh();
+setInterval(refresh, interval);
+</script>
+</body>
+</html>
+`
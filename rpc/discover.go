@@ -0,0 +1,85 @@
+package rpc
+
+import (
+	"fmt"
+	"github.com/zl03jsj/rpc2"
+	"gitlab.forceup.in/zengliang/rpc2-center/common"
+	"gitlab.forceup.in/zengliang/rpc2-center/httpserver"
+	"net/http"
+	"strings"
+)
+
+// 查询满足条件的在线节点, 包括注册在peer上的节点, 不包括正在下线和管理员设置为不分配请求的节点
+func (c *Center) Discover(filter common.DiscoverFilter) []common.Register {
+	nodes := []common.Register{}
+
+	c.rwMu.RLock()
+	for _, nodeGroup := range c.verNameMapNodeGroup {
+		for _, status := range nodeGroup.GetNodeStatus() {
+			if !status.Draining && !status.Cordoned && filter.Match(&status.Register) {
+				nodes = append(nodes, status.Register)
+			}
+		}
+	}
+	c.rwMu.RUnlock()
+
+	for _, reg := range c.peers.getNodes() {
+		if filter.Match(&reg) {
+			nodes = append(nodes, reg)
+		}
+	}
+	return nodes
+}
+
+func (c *Center) byDiscover(client *rpc2.Client, filter *common.DiscoverFilter, res *[]common.Register) error {
+	if !c.isAuthorizedClient(client) {
+		return common.NewCodeError(common.ErrRegisterDenied, "discover denied")
+	}
+
+	*res = c.Discover(*filter)
+	return nil
+}
+
+// http查询: /discover?version=v1&name=pay&tag=a&meta.k=v
+// 只返回服务发现需要的信息, 不能按环境变量过滤
+func (c *Center) handleDiscover(w http.ResponseWriter, req *http.Request) {
+	query := req.URL.Query()
+	filter := common.DiscoverFilter{
+		Version: query.Get("version"),
+		Name:    query.Get("name"),
+		Tag:     query.Get("tag"),
+		Meta:    make(map[string]string),
+	}
+	for k := range query {
+		if strings.HasPrefix(k, "meta.") {
+			filter.Meta[strings.TrimPrefix(k, "meta.")] = query.Get(k)
+		}
+	}
+
+	instances := []common.DiscoverInstance{}
+	for _, reg := range c.Discover(filter) {
+		instances = append(instances, common.DiscoverInstance{
+			Service: reg.Service,
+			Id:      reg.Id,
+			StartAt: reg.StartAt,
+			Meta:    reg.Meta,
+		})
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	httpserver.ResponseDataByIndent(w, common.HttpUserResponse{Result: instances})
+}
+
+// 向center查询满足条件的在线节点
+func (n *Node) Discover(filter common.DiscoverFilter) ([]common.Register, error) {
+	client := n.getClient()
+	if client == nil {
+		return nil, fmt.Errorf("client is nil")
+	}
+
+	nodes := []common.Register{}
+	if err := client.Call(common.MethodCenterDiscover, &filter, &nodes); err != nil {
+		return nil, err
+	}
+	return nodes, nil
+}
@@ -0,0 +1,35 @@
+package rpc
+
+import (
+	"github.com/zl03jsj/rpc2"
+	"gitlab.forceup.in/zengliang/rpc2-center/common"
+	"gitlab.forceup.in/zengliang/rpc2-center/loger"
+	"testing"
+)
+
+// 正在下线和被cordon的节点不会被发现
+func TestDiscover(t *testing.T) {
+	c, err := NewCenter(common.ConfigCenter{}, "", &loger.MyLoger{}, nil, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	sng := &NodeGroup{ILoger: &loger.MyLoger{}}
+	clients := map[string]*rpc2.Client{}
+	for _, id := range []string{"a", "b", "c"} {
+		clients[id] = &rpc2.Client{}
+		sng.Register(clients[id], &common.Register{Service: common.Service{Version: "v1", Name: "pay"}, Id: id})
+	}
+	c.verNameMapNodeGroup["v1.pay"] = sng
+
+	sng.Drain(clients["b"])
+	sng.Cordon("c", true)
+	if got := nodeIds(c.Discover(common.DiscoverFilter{Name: "pay"})); !equalStrings(got, []string{"a"}) {
+		t.Errorf("nodes = %v, want [a]", got)
+	}
+
+	sng.Cordon("c", false)
+	if got := nodeIds(c.Discover(common.DiscoverFilter{Name: "pay"})); !equalStrings(got, []string{"a", "c"}) {
+		t.Errorf("nodes = %v, want [a c]", got)
+	}
+}
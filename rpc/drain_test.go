@@ -0,0 +1,78 @@
+package rpc
+
+import (
+	"context"
+	"errors"
+	"gitlab.forceup.in/zengliang/rpc2-center/common"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// center上服务的节点状态
+func testNodeStatus(c *Center, srvKey string) []common.NodeStatus {
+	c.rwMu.RLock()
+	defer c.rwMu.RUnlock()
+
+	if nodeGroup, ok := c.verNameMapNodeGroup[srvKey]; ok {
+		return nodeGroup.GetNodeStatus()
+	}
+	return nil
+}
+
+// 停止节点时先通知center不再分配请求, 等正在处理的请求结束后再注销
+func TestStopNodeDrain(t *testing.T) {
+	c, addr := startTestCenter(t, "c1")
+	defer c.Shutdown(context.Background())
+
+	release := make(chan struct{})
+	pay := startTestNode(t, "pay", addr, func(ag *ApiInfoGroup) {
+		ag.RegisterCaller("slow", func(req *common.Request, res *common.Response) {
+			<-release
+			res.SetOkResult("done")
+		})
+	})
+	cli := startTestNode(t, "cli", addr, nil)
+	defer StopNode(cli)
+
+	slow := make(chan error, 1)
+	go func() {
+		var out string
+		slow <- cli.Invoke(context.Background(), common.NewMethod("v1", "pay", "slow"), nil, &out)
+	}()
+	waitFor(t, "request in flight", func() bool { return atomic.LoadInt64(&pay.inFlight) == 1 })
+
+	stopped := make(chan struct{})
+	go func() {
+		StopNode(pay)
+		close(stopped)
+	}()
+	waitFor(t, "node draining", func() bool {
+		status := testNodeStatus(c, "v1.pay")
+		return len(status) == 1 && status[0].Draining
+	})
+
+	// 正在下线的节点不再分配新的请求
+	err := cli.Invoke(context.Background(), common.NewMethod("v1", "pay", "slow"), nil, nil)
+	var codeErr *common.CodeError
+	if !errors.As(err, &codeErr) || codeErr.Code != common.ErrNotFindService {
+		t.Errorf("Invoke() while draining = %v, want code %d", err, common.ErrNotFindService)
+	}
+
+	select {
+	case <-stopped:
+		t.Fatalf("StopNode() returned before the request finished")
+	case <-time.After(time.Millisecond * 100):
+	}
+
+	close(release)
+	if err := <-slow; err != nil {
+		t.Errorf("in flight request = %v", err)
+	}
+	select {
+	case <-stopped:
+	case <-time.After(time.Second * 5):
+		t.Fatalf("StopNode() not return after the request finished")
+	}
+	waitFor(t, "node unregistered", func() bool { return len(testNodeStatus(c, "v1.pay")) == 0 })
+}
@@ -0,0 +1,107 @@
+package rpc
+
+import (
+	"context"
+	"fmt"
+	"gitlab.forceup.in/zengliang/rpc2-center/common"
+	"gitlab.forceup.in/zengliang/rpc2-center/tracing"
+	"time"
+)
+
+type (
+	invokeOptions struct {
+		timeout time.Duration
+	}
+
+	// Invoke/InvokeNotify的可选参数
+	InvokeOption func(req *common.Request, opts *invokeOptions)
+)
+
+// 指定节点的tag或标签选择器
+func WithTag(tag string) InvokeOption {
+	return func(req *common.Request, opts *invokeOptions) {
+		req.Method.Tag = tag
+	}
+}
+
+// 调用超时, 与ctx的截止时间取较早的
+func WithTimeout(timeout time.Duration) InvokeOption {
+	return func(req *common.Request, opts *invokeOptions) {
+		opts.timeout = timeout
+	}
+}
+
+// 设置请求上下文, value需要是gob可以编码的基础类型
+func WithContext(key string, value interface{}) InvokeOption {
+	return func(req *common.Request, opts *invokeOptions) {
+		if req.Context == nil {
+			req.Context = make(common.Context)
+		}
+		req.Context[key] = value
+	}
+}
+
+// 构造请求, 编码参数并应用可选参数
+func newInvokeRequest(method common.Method, in interface{}, opts []InvokeOption) (*common.Request, *invokeOptions, error) {
+	if method.Version == "" || method.Name == "" || method.Function == "" {
+		return nil, nil, fmt.Errorf("invalid method %s.%s", method.GetKey(), method.Function)
+	}
+
+	req := &common.Request{Method: method}
+	if err := req.Data.SetValue(in); err != nil {
+		return nil, nil, err
+	}
+
+	options := &invokeOptions{}
+	for _, opt := range opts {
+		opt(req, options)
+	}
+	return req, options, nil
+}
+
+// 调用method, 版本中可以有".", 所以服务和函数分开给出, 应答的错误码不为0时返回*common.CodeError,
+// 成功时把结果解码到out, out为nil时忽略结果
+func (n *Node) Invoke(ctx context.Context, method common.Method, in interface{}, out interface{}, opts ...InvokeOption) error {
+	req, options, err := newInvokeRequest(method, in, opts)
+	if err != nil {
+		return err
+	}
+
+	if options.timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, options.timeout)
+		defer cancel()
+	}
+
+	res := &common.Response{}
+	if err = n.CallContext(ctx, req, res); err != nil {
+		return err
+	}
+	if res.Data.Err != common.ErrOk {
+		return &common.CodeError{Code: res.Data.Err, Msg: res.Data.ErrMsg}
+	}
+
+	if out == nil {
+		return nil
+	}
+	if err = res.Data.GetResult(out); err != nil {
+		return common.NewCodeError(common.ErrDataCorrupted, "decode result: %s", err.Error())
+	}
+	return nil
+}
+
+// 通知method, 不等待处理结果
+func (n *Node) InvokeNotify(ctx context.Context, method common.Method, in interface{}, opts ...InvokeOption) error {
+	req, _, err := newInvokeRequest(method, in, opts)
+	if err != nil {
+		return err
+	}
+	if err = ctx.Err(); err != nil {
+		return err
+	}
+
+	if !tracing.Extract(req).IsValid() {
+		tracing.Inject(req, tracing.SpanFromContext(ctx).Context())
+	}
+	return n.Notify(req, &common.Response{})
+}
@@ -0,0 +1,89 @@
+package rpc
+
+import (
+	"context"
+	"errors"
+	"gitlab.forceup.in/zengliang/rpc2-center/common"
+	"testing"
+	"time"
+)
+
+func TestNewInvokeRequest(t *testing.T) {
+	method := common.NewMethod("v1", "pay", "charge")
+	req, options, err := newInvokeRequest(method, &typedReq{Amount: 1}, []InvokeOption{
+		WithTag("a"), WithTimeout(time.Second), WithContext("uid", "1"),
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if req.Method.Tag != "a" || options.timeout != time.Second || req.Context["uid"] != "1" {
+		t.Errorf("options not applied: %+v %+v", req, options)
+	}
+	in := &typedReq{}
+	if err = req.Data.GetValue(in); err != nil || in.Amount != 1 {
+		t.Errorf("GetValue() = %d, %v", in.Amount, err)
+	}
+
+	if _, _, err = newInvokeRequest(common.NewMethod("v1", "", "charge"), nil, nil); err == nil {
+		t.Errorf("invalid method should fail")
+	}
+	if _, _, err = newInvokeRequest(method, make(chan int), nil); err == nil {
+		t.Errorf("unencodable parameter should fail")
+	}
+}
+
+func TestInvoke(t *testing.T) {
+	c, addr := startTestCenter(t, "c1")
+	defer c.Shutdown(context.Background())
+
+	refunded := make(chan int, 1)
+	pay := startTestNode(t, "pay", addr, func(ag *ApiInfoGroup) {
+		ag.RegisterTyped("charge", (&typedService{}).Charge)
+		ag.RegisterTyped("refunded", func(ctx context.Context, in *typedReq) error {
+			refunded <- in.Amount
+			return nil
+		})
+		ag.RegisterTyped("sleep", func(ctx context.Context, in *typedReq) (*typedRes, error) {
+			time.Sleep(time.Millisecond * time.Duration(in.Amount))
+			return &typedRes{}, nil
+		})
+	})
+	defer StopNode(pay)
+	cli := startTestNode(t, "cli", addr, nil)
+	defer StopNode(cli)
+
+	ctx := context.Background()
+	out := &typedRes{}
+	if err := cli.Invoke(ctx, common.NewMethod("v1", "pay", "charge"), &typedReq{Amount: 2}, out); err != nil || out.Amount != 4 {
+		t.Errorf("Invoke() = %d, %v", out.Amount, err)
+	}
+
+	err := cli.Invoke(ctx, common.NewMethod("v1", "pay", "charge"), &typedReq{Amount: -1}, nil)
+	var codeErr *common.CodeError
+	if !errors.As(err, &codeErr) || codeErr.Code != common.ErrNoPermission {
+		t.Errorf("Invoke() = %v, want code %d", err, common.ErrNoPermission)
+	}
+
+	err = cli.Invoke(ctx, common.NewMethod("v1", "pay", "sleep"), &typedReq{Amount: 500}, nil,
+		WithTimeout(time.Millisecond*50))
+	if err != context.DeadlineExceeded {
+		t.Errorf("Invoke() = %v, want %v", err, context.DeadlineExceeded)
+	}
+
+	canceled, cancel := context.WithCancel(ctx)
+	cancel()
+	if err = cli.InvokeNotify(canceled, common.NewMethod("v1", "pay", "refunded"), &typedReq{Amount: 3}); err == nil {
+		t.Errorf("InvokeNotify() with canceled ctx should fail")
+	}
+	if err = cli.InvokeNotify(ctx, common.NewMethod("v1", "pay", "refunded"), &typedReq{Amount: 3}); err != nil {
+		t.Errorf("InvokeNotify() = %v", err)
+	}
+	select {
+	case amount := <-refunded:
+		if amount != 3 {
+			t.Errorf("refunded = %d, want 3", amount)
+		}
+	case <-time.After(time.Second * 5):
+		t.Errorf("notify not received")
+	}
+}
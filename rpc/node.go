@@ -2,22 +2,38 @@ package rpc
 
 import (
 	"context"
+	"crypto/tls"
 	"fmt"
 	"github.com/zl03jsj/rpc2"
 	"gitlab.forceup.in/zengliang/rpc2-center/common"
 	"gitlab.forceup.in/zengliang/rpc2-center/loger"
 	"gitlab.forceup.in/zengliang/rpc2-center/tools"
+	"gitlab.forceup.in/zengliang/rpc2-center/tracing"
 	"net"
 	"sync"
+	"sync/atomic"
 	"time"
 )
 
 type (
-	ConnectCenterStatusCallBack func(status common.ConnectStatus)
-	Node                        struct {
-		*rpc2.Client
+	// attempt为ConnectStatusReconnecting时连续重连的次数, 其他状态为0
+	ConnectCenterStatusCallBack func(status common.ConnectStatus, attempt int)
+
+	// 与一个center的连接
+	centerLink struct {
+		addr    string
+		client  *rpc2.Client // 注册成功后设置, 断开后为nil
+		closing bool         // center通知即将关闭, 不再使用这个连接发起请求
+	}
+
+	Node struct {
+		inFlight int64
+		next     uint32
+
+		*rpc2.Client // 最近注册成功的center连接, 没有可用连接时为nil
 		loger.ILoger
-		rwMu sync.RWMutex
+		rwMu  sync.RWMutex
+		links []*centerLink
 
 		cfgNode common.ConfigNode
 		cb      ConnectCenterStatusCallBack
@@ -30,8 +46,14 @@ type (
 
 		statusMu sync.Mutex
 		stopped  bool
+		cancel   context.CancelFunc
 
 		befor_bycall BeforApiCaller
+
+		tracer *tracing.Tracer
+
+		watchers   []*nodeWatcher
+		watchNodes map[string]common.Register // 已收到注册事件的节点, 按id去掉重复的事件
 	}
 )
 
@@ -42,12 +64,20 @@ func NewNode(conf common.ConfigNode, meta string, iLoger loger.ILoger, cb Connec
 		ILoger:   iLoger,
 		apiGroup: NewApiGroup(nil),
 	}
+	node.apiGroup.SetLoger(iLoger)
 
 	node.regData.StartAt = tools.GetDateNowString()
 	node.regData.Meta = tools.ParseMeta(meta)
 	node.regData.Env = tools.GetOsEnv(node.cfgNode.Env)
 	node.regData.Service = node.cfgNode.Service
 
+	for _, addr := range conf.GetRpcAddrs() {
+		node.links = append(node.links, &centerLink{addr: addr})
+	}
+	if len(node.links) == 0 {
+		return nil, fmt.Errorf("no center address")
+	}
+
 	return node, nil
 }
 
@@ -58,14 +88,51 @@ func (n *Node) GetApiGroup() *ApiInfoGroup {
 func StartNode(ctx context.Context, n *Node) {
 	n.initFunction()
 
+	ctx, n.cancel = context.WithCancel(ctx)
 	n.startToCenter(ctx)
 }
 
+// 先通知center不再分配请求, 等待正在处理的请求结束后注销并断开连接
 func StopNode(n *Node) {
+	n.drain()
+
 	n.setStopped(true)
+	if n.cancel != nil {
+		n.cancel()
+	}
 	n.wg.Wait()
 }
 
+func (n *Node) drain() {
+	clients := n.getClients()
+	if len(clients) == 0 {
+		return
+	}
+
+	for _, client := range clients {
+		var res string
+		if err := client.Call(common.MethodCenterDrain, n.regData.GetKey(), &res); err != nil {
+			n.Error("drain err: %s", err.Error())
+		}
+	}
+
+	timeout := time.Duration(n.cfgNode.DrainTimeout) * time.Second
+	if timeout <= 0 {
+		timeout = time.Second * 10
+	}
+
+	deadline := time.Now().Add(timeout)
+	for atomic.LoadInt64(&n.inFlight) > 0 && time.Now().Before(deadline) {
+		time.Sleep(time.Millisecond * 50)
+	}
+
+	if count := atomic.LoadInt64(&n.inFlight); count > 0 {
+		n.Error("drain timeout, %d requests still running", count)
+	} else {
+		n.Info("drain done")
+	}
+}
+
 func (n *Node) setStopped(status bool) {
 	n.statusMu.Lock()
 	defer n.statusMu.Unlock()
@@ -81,13 +148,28 @@ func (n *Node) isStopped() bool {
 func (n *Node) initFunction() {
 	n.regData.CallerList = n.apiGroup.GetCallerNameList()
 	n.regData.NotifierList = n.apiGroup.GetNotifierNameList()
+	n.regData.IdempotentList = n.apiGroup.GetIdempotentNameList()
 }
 
 func (n *Node) SetBeforCall(befor_call BeforApiCaller) {
 	n.befor_bycall = befor_call
 }
 
+func (n *Node) notifyStatus(status common.ConnectStatus, attempt int) {
+	if n.cb != nil {
+		n.cb(status, attempt)
+	}
+}
+
+// 设置tracer, 发起和处理的每个请求记录一个span
+func (n *Node) SetTracer(tracer *tracing.Tracer) {
+	n.tracer = tracer
+}
+
 func (n *Node) byCall(client *rpc2.Client, req *common.Request, res *common.Response) error {
+	atomic.AddInt64(&n.inFlight, 1)
+	defer atomic.AddInt64(&n.inFlight, -1)
+
 	n.Info("begin call:%s", req.Method.Function)
 	defer n.Info("end call:%s-%d", req.Method.Function, res.Data.Err)
 
@@ -96,6 +178,18 @@ func (n *Node) byCall(client *rpc2.Client, req *common.Request, res *common.Resp
 		return nil
 	}
 
+	span, finish := startRequestSpan(n.tracer, "handle", tracing.Extract(req), req, res)
+	defer finish()
+
+	ctx, cancel := req.WithDeadline(context.Background())
+	defer cancel()
+	if ctx.Err() != nil {
+		res.Data.Err = common.ContextErrCode(ctx.Err())
+		res.Data.ErrMsg = ctx.Err().Error()
+		return nil
+	}
+	req.SetCtx(tracing.ContextWithSpan(ctx, span))
+
 	if n.befor_bycall != nil {
 		if !n.befor_bycall(req, res) {
 			return nil
@@ -108,6 +202,9 @@ func (n *Node) byCall(client *rpc2.Client, req *common.Request, res *common.Resp
 }
 
 func (n *Node) byNotify(client *rpc2.Client, req *common.Request, res *common.Response) error {
+	atomic.AddInt64(&n.inFlight, 1)
+	defer atomic.AddInt64(&n.inFlight, -1)
+
 	n.Info("begin notify:%s", req.Method.Function)
 	defer n.Info("end notify:%s-%d", req.Method.Function, res.Data.Err)
 
@@ -116,6 +213,10 @@ func (n *Node) byNotify(client *rpc2.Client, req *common.Request, res *common.Re
 		return nil
 	}
 
+	span, finish := startRequestSpan(n.tracer, "handle", tracing.Extract(req), req, res)
+	defer finish()
+	req.SetCtx(tracing.ContextWithSpan(context.Background(), span))
+
 	n.apiGroup.HandleNotify(req, res)
 
 	return nil
@@ -134,22 +235,63 @@ func (n *Node) byKeepAlive(client *rpc2.Client, req *string, res *string) error
 	return nil
 }
 
+// center关闭前通知节点, 不再通过这个center发起请求, 连接随后断开并由重连逻辑处理
+func (n *Node) byCenterClosing(client *rpc2.Client, centerKey *string, res *common.Response) error {
+	n.Info("center %s is closing", *centerKey)
+
+	n.rwMu.Lock()
+	defer n.rwMu.Unlock()
+
+	for _, link := range n.links {
+		if link.client == client {
+			link.closing = true
+		}
+	}
+	if n.Client == client {
+		n.resetClient()
+	}
+	return nil
+}
+
 func (n *Node) Call(req *common.Request, res *common.Response) error {
+	return n.CallContext(context.Background(), req, res)
+}
+
+// 带超时/取消的调用, ctx的截止时间随请求传给center和目标节点,
+// ctx结束时不再等待应答, res.Data.Err为ErrCallTimeout或ErrCallCanceled
+func (n *Node) CallContext(ctx context.Context, req *common.Request, res *common.Response) error {
 	if n.isStopped() {
 		return fmt.Errorf("client is stopped")
 	}
 
-	n.rwMu.RLock()
-	defer n.rwMu.RUnlock()
+	if d, ok := ctx.Deadline(); ok {
+		req.SetDeadline(d)
+	}
 
-	var err error
-	if n.Client != nil {
-		err = n.Client.Call(common.MethodCenterCall, req, res)
-	} else {
-		err = fmt.Errorf("client is nil")
+	client := n.getClient()
+	if client == nil {
+		return fmt.Errorf("client is nil")
 	}
 
-	return err
+	_, finish := startRequestSpan(n.tracer, metricTypeCall, n.parentSpan(ctx, req), req, res)
+	defer finish()
+
+	reply := &common.Response{}
+	call := client.Go(common.MethodCenterCall, req, reply, make(chan *rpc2.Call, 1))
+	select {
+	case <-call.Done:
+	case <-ctx.Done():
+		res.Data.Err = common.ContextErrCode(ctx.Err())
+		res.Data.ErrMsg = ctx.Err().Error()
+		return ctx.Err()
+	}
+
+	if call.Error != nil {
+		return call.Error
+	}
+
+	*res = *reply
+	return nil
 }
 
 func (n *Node) Notify(req *common.Request, res *common.Response) error {
@@ -157,20 +299,72 @@ func (n *Node) Notify(req *common.Request, res *common.Response) error {
 		return fmt.Errorf("client is stopped")
 	}
 
-	n.rwMu.RLock()
-	defer n.rwMu.RUnlock()
+	_, finish := startRequestSpan(n.tracer, metricTypeNotify, tracing.Extract(req), req, res)
+	defer finish()
 
 	var err error
-	if n.Client != nil {
-		err = n.Client.Notify(common.MethodCenterNotify, req)
+	if client := n.getClient(); client != nil {
+		err = client.Notify(common.MethodCenterNotify, req)
 	} else {
 		err = fmt.Errorf("client is nil")
 	}
 	return err
 }
 
-func (n *Node) connectToCenter() (*rpc2.Client, error) {
-	conn, err := net.Dial("tcp", n.cfgNode.RpcAddr)
+// 已注册的center连接, active模式下轮流使用
+func (n *Node) getClient() *rpc2.Client {
+	clients := n.getClients()
+	if len(clients) == 0 {
+		return nil
+	}
+	return clients[int(atomic.AddUint32(&n.next, 1))%len(clients)]
+}
+
+func (n *Node) getClients() []*rpc2.Client {
+	n.rwMu.RLock()
+	defer n.rwMu.RUnlock()
+
+	clients := []*rpc2.Client{}
+	for _, link := range n.links {
+		if link.client != nil && !link.closing {
+			clients = append(clients, link.client)
+		}
+	}
+	return clients
+}
+
+// 当前连接不可用时换成其他可用的连接, 需要持有rwMu
+func (n *Node) resetClient() {
+	n.Client = nil
+	for _, link := range n.links {
+		if link.client != nil && !link.closing {
+			n.Client = link.client
+			return
+		}
+	}
+}
+
+// 请求中没有trace context时, 使用ctx中正在处理的请求的span
+func (n *Node) parentSpan(ctx context.Context, req *common.Request) tracing.SpanContext {
+	if sc := tracing.Extract(req); sc.IsValid() {
+		return sc
+	}
+	return tracing.SpanFromContext(ctx).Context()
+}
+
+func (n *Node) connectToCenter(addr string) (*rpc2.Client, error) {
+	var conn net.Conn
+	var err error
+	if n.cfgNode.TLS.Enable {
+		var tlsConf *tls.Config
+		tlsConf, err = n.cfgNode.TLS.ClientConfig(addr)
+		if err != nil {
+			return nil, err
+		}
+		conn, err = tls.Dial("tcp", addr, tlsConf)
+	} else {
+		conn, err = net.Dial("tcp", addr)
+	}
 	if err != nil {
 		return nil, err
 	}
@@ -179,121 +373,138 @@ func (n *Node) connectToCenter() (*rpc2.Client, error) {
 	return clt, nil
 }
 
-func (n *Node) registerToCenter() error {
+func (n *Node) registerToCenter(client *rpc2.Client) error {
 	var res string
 
-	var err error
-	if n.Client != nil {
-		err = n.Client.Call(common.MethodCenterRegister, &n.regData, &res)
+	reg := n.regData
+	reg.Sign(n.cfgNode.AuthMode, n.cfgNode.Secret)
+	err := client.Call(common.MethodCenterRegister, &reg, &res)
+	if err == nil {
 		n.Info("Register to center ok %s.%s", n.cfgNode.Version, n.cfgNode.Name)
-	} else {
-		err = fmt.Errorf("client is nil")
 	}
 	return err
 }
 
-func (n *Node) unRegisterToCenter() error {
+func (n *Node) unRegisterToCenter(client *rpc2.Client) error {
 	var res string
 
-	var err error
-	if n.Client != nil {
-		n.Client.Call(common.MethodCenterUnRegister, n.cfgNode, &res)
-		n.Info("UnRegister to center ok %s.%s", n.cfgNode.Version, n.cfgNode.Name)
-	} else {
-		err = fmt.Errorf("client is nil")
-	}
+	err := client.Call(common.MethodCenterUnRegister, n.regData.GetKey(), &res)
+	n.Info("UnRegister to center ok %s.%s", n.cfgNode.Version, n.cfgNode.Name)
 
 	return err
 }
 
+// failover模式只有一个routine依次尝试所有center, active模式每个center一个routine
 func (n *Node) startToCenter(ctx context.Context) {
-	go func() {
-		n.wg.Add(1)
-		n.Info("startToCenter loop start...")
-
-		defer func() {
-			n.wg.Done()
-			n.Info("startToCenter loop stop...")
-		}()
-
-		for {
-			// connect and register
-			func() {
-				n.rwMu.Lock()
-				defer n.rwMu.Unlock()
-
-				var err error
-				if n.Client == nil {
-					n.Info("client try to connect...")
-					n.Client, err = n.connectToCenter()
-					if n.Client != nil && err == nil {
-						n.Info("client connect to center...")
-						n.Client.Handle(common.MethodNodeCall, n.byCall)
-						n.Client.Handle(common.MethodNodeNotify, n.byNotify)
-						n.Client.Handle(common.MethodNodeKeepAlive, n.byKeepAlive)
-
-						go n.Client.Run()
-
-						n.registerToCenter()
-
-						if n.cb != nil {
-							n.cb(common.ConnectStatusConnected)
-						}
-					}
-				}
-
-				if err != nil {
-					if n.Client != nil {
-						n.Client.Close()
-						n.Client = nil
-					}
-					n.Error("connect failed, %s", err.Error())
-				}
-			}()
-
-			// listen
-			func() {
-				n.rwMu.RLock()
-				defer n.rwMu.RUnlock()
-
-				if n.Client == nil {
-					return
-				}
-
-				n.Info("client run...")
-				select {
-				case <-ctx.Done():
-					n.Error("user disconnect client ...")
-					break
-				case <-n.Client.DisconnectNotify():
-					n.Error("client disconnect...")
-					break
-				}
-
-				if n.cb != nil {
-					n.cb(common.ConnectStatusDisConnected)
-				}
-			}()
-
-			// unregister and close
-			func() {
-				n.rwMu.Lock()
-				defer n.rwMu.Unlock()
-				n.unRegisterToCenter()
-				if n.Client != nil {
-					n.Client.Close()
-					n.Client = nil
-
-					n.Info("reset client...")
-				}
-			}()
-
-			if n.isStopped() {
+	if n.cfgNode.CenterMode == common.CenterModeActive {
+		for _, link := range n.links {
+			n.wg.Add(1)
+			go n.loopToCenter(ctx, []*centerLink{link})
+		}
+		return
+	}
+
+	n.wg.Add(1)
+	go n.loopToCenter(ctx, n.links)
+}
+
+// 依次连接links中的center, 连接断开或失败后尝试下一个,
+// 每轮都没有注册成功, 或者注册成功的连接断开后, 按退避策略等待重试
+func (n *Node) loopToCenter(ctx context.Context, links []*centerLink) {
+	n.Info("startToCenter loop start...")
+
+	defer func() {
+		n.wg.Done()
+		n.Info("startToCenter loop stop...")
+	}()
+
+	backoff := n.cfgNode.Backoff.NewBackoff()
+	failed := 0
+	for i := 0; ; i = (i + 1) % len(links) {
+		if n.runLink(ctx, links[i], backoff) {
+			failed = 0
+		} else {
+			failed++
+		}
+
+		if n.isStopped() || ctx.Err() != nil {
+			return
+		}
+
+		// 注册成功后backoff已经重置, 连接断开时等待初始间隔, 避免注册后马上断开时不停地重连
+		if failed%len(links) == 0 {
+			wait := backoff.Next()
+			n.Info("wait %s to connect, attempt %d...", wait, backoff.Attempt())
+			n.notifyStatus(common.ConnectStatusReconnecting, backoff.Attempt())
+
+			select {
+			case <-ctx.Done():
 				return
+			case <-time.After(wait):
 			}
-
-			n.Info("wait 5 second to connect...")
-			time.Sleep(time.Second * 5)
 		}
-	}()
+	}
+}
+
+// 连接并注册到center, 直到连接断开或ctx结束, 注册成功时重置backoff并返回true
+func (n *Node) runLink(ctx context.Context, link *centerLink, backoff *tools.Backoff) bool {
+	// connect and register
+	n.Info("client try to connect %s...", link.addr)
+	client, err := n.connectToCenter(link.addr)
+	if err != nil {
+		n.Error("connect failed, %s", err.Error())
+		return false
+	}
+
+	n.Info("client connect to center %s...", link.addr)
+	client.Handle(common.MethodNodeCall, n.byCall)
+	client.Handle(common.MethodNodeNotify, n.byNotify)
+	client.Handle(common.MethodNodeKeepAlive, n.byKeepAlive)
+	client.Handle(common.MethodNodeClosing, n.byCenterClosing)
+	client.Handle(common.MethodNodeWatch, n.byWatchEvent)
+
+	go client.Run()
+
+	if err = n.registerToCenter(client); err != nil {
+		client.Close()
+		n.Error("connect failed, %s", err.Error())
+		return false
+	}
+
+	n.rwMu.Lock()
+	link.client = client
+	link.closing = false
+	n.Client = client
+	n.rwMu.Unlock()
+	backoff.Reset()
+
+	n.watchCenter(client)
+
+	n.notifyStatus(common.ConnectStatusConnected, 0)
+
+	// listen
+	n.Info("client run...")
+	select {
+	case <-ctx.Done():
+		n.Error("user disconnect client ...")
+	case <-client.DisconnectNotify():
+		n.Error("client disconnect %s...", link.addr)
+	}
+
+	n.notifyStatus(common.ConnectStatusDisConnected, 0)
+
+	// unregister and close
+	n.rwMu.Lock()
+	link.client = nil
+	link.closing = false
+	if n.Client == client {
+		n.resetClient()
+	}
+	n.rwMu.Unlock()
+
+	n.unRegisterToCenter(client)
+	client.Close()
+	n.Info("reset client...")
+
+	return true
 }
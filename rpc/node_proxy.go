@@ -1,17 +1,28 @@
 package rpc
 
 import (
+	"context"
 	"github.com/zl03jsj/rpc2"
 	"gitlab.forceup.in/zengliang/rpc2-center/common"
 	"gitlab.forceup.in/zengliang/rpc2-center/loger"
+	"gitlab.forceup.in/zengliang/rpc2-center/tools"
 	"strings"
 	"sync"
 	"sync/atomic"
+	"time"
 )
 
 type (
 	NodeInfo struct {
-		client *rpc2.Client
+		inFlight    int64
+		calls       int64
+		failures    int64
+		keepAliveAt int64 // 最近一次心跳成功的时间(unix秒), center自己注册的节点不检查心跳, 为0
+
+		client   *rpc2.Client
+		breaker  *CircuitBreaker
+		draining bool
+		cordoned bool // 管理员设置不再分配请求
 
 		RegisterData common.Register
 	}
@@ -20,11 +31,15 @@ type (
 		loger.ILoger
 		nodeInfo common.Service
 
-		callFunctionMap   map[string]interface{}
-		notifyFunctionMap map[string]interface{}
+		callFunctionMap       map[string]interface{}
+		notifyFunctionMap     map[string]interface{}
+		idempotentFunctionMap map[string]interface{}
+
+		retry    common.RetryPolicy
+		balancer Balancer
+		breaker  common.BreakerConfig
 
 		rwMu  sync.RWMutex
-		index int64
 		nodes []*NodeInfo
 	}
 )
@@ -36,6 +51,10 @@ func (sng *NodeGroup) Register(client *rpc2.Client, reg *common.Register) error
 	sng.nodeInfo.Version = reg.Version
 	sng.nodeInfo.Name = reg.Name
 
+	if sng.balancer == nil {
+		sng.balancer = &roundRobinBalancer{}
+	}
+
 	if sng.callFunctionMap == nil {
 		sng.callFunctionMap = make(map[string]interface{})
 	}
@@ -48,11 +67,21 @@ func (sng *NodeGroup) Register(client *rpc2.Client, reg *common.Register) error
 	for _, cc := range reg.NotifierList {
 		sng.notifyFunctionMap[strings.ToLower(cc)] = struct{}{}
 	}
+	if sng.idempotentFunctionMap == nil {
+		sng.idempotentFunctionMap = make(map[string]interface{})
+	}
+	for _, cc := range reg.IdempotentList {
+		sng.idempotentFunctionMap[strings.ToLower(cc)] = struct{}{}
+	}
 
 	si := &NodeInfo{
 		client:       client,
+		breaker:      NewCircuitBreaker(sng.breaker),
 		RegisterData: *reg,
 	}
+	if client != nil {
+		si.keepAliveAt = time.Now().Unix()
+	}
 	sng.nodes = append(sng.nodes, si)
 
 	sng.Debug("reg-%s.%s(%s), all-%d", reg.Version, reg.Name, reg.Tag, len(sng.nodes))
@@ -76,6 +105,14 @@ func (sng *NodeGroup) UnRegister(client *rpc2.Client) (*common.Register, error)
 	return reg, nil
 }
 
+// 设置负载均衡策略
+func (sng *NodeGroup) SetBalancer(balancer Balancer) {
+	sng.rwMu.Lock()
+	defer sng.rwMu.Unlock()
+
+	sng.balancer = balancer
+}
+
 func (sng *NodeGroup) GetNodeInfo() common.Service {
 	sng.rwMu.RLock()
 	defer sng.rwMu.RUnlock()
@@ -95,6 +132,94 @@ func (sng *NodeGroup) GetNodes() []common.Register {
 	return infos
 }
 
+// 节点准备下线, 不再选择该节点
+func (sng *NodeGroup) Drain(client *rpc2.Client) {
+	sng.rwMu.Lock()
+	defer sng.rwMu.Unlock()
+
+	for _, v := range sng.nodes {
+		if v.client == client {
+			v.draining = true
+			sng.Info("drain-%s.%s(%s)", sng.nodeInfo.Version, sng.nodeInfo.Name, v.RegisterData.Tag)
+		}
+	}
+}
+
+// 记录节点心跳成功
+func (sng *NodeGroup) KeepAlive(client *rpc2.Client) {
+	sng.rwMu.RLock()
+	defer sng.rwMu.RUnlock()
+
+	for _, v := range sng.nodes {
+		if v.client == client {
+			atomic.StoreInt64(&v.keepAliveAt, time.Now().Unix())
+		}
+	}
+}
+
+// 设置节点是否停止分配请求, 没有该节点时返回false
+func (sng *NodeGroup) Cordon(id string, cordoned bool) bool {
+	sng.rwMu.Lock()
+	defer sng.rwMu.Unlock()
+
+	for _, v := range sng.nodes {
+		if v.RegisterData.Id == id {
+			v.cordoned = cordoned
+			sng.Info("cordon-%s.%s(%s) %v", sng.nodeInfo.Version, sng.nodeInfo.Name, v.RegisterData.Tag, cordoned)
+			return true
+		}
+	}
+	return false
+}
+
+// 获取节点的连接
+func (sng *NodeGroup) GetClient(id string) *rpc2.Client {
+	sng.rwMu.RLock()
+	defer sng.rwMu.RUnlock()
+
+	for _, v := range sng.nodes {
+		if v.RegisterData.Id == id {
+			return v.client
+		}
+	}
+	return nil
+}
+
+// 获取节点运行状态
+func (sng *NodeGroup) GetNodeStatus() []common.NodeStatus {
+	sng.rwMu.RLock()
+	defer sng.rwMu.RUnlock()
+
+	infos := []common.NodeStatus{}
+	for _, v := range sng.nodes {
+		infos = append(infos, common.NodeStatus{
+			Register:    v.RegisterData,
+			Breaker:     v.breaker.State().String(),
+			InFlight:    v.InFlight(),
+			Draining:    v.draining,
+			Cordoned:    v.cordoned,
+			Calls:       atomic.LoadInt64(&v.calls),
+			Failures:    atomic.LoadInt64(&v.failures),
+			Uptime:      tools.GetUptime(v.RegisterData.StartAt),
+			KeepAliveAt: atomic.LoadInt64(&v.keepAliveAt),
+		})
+	}
+
+	return infos
+}
+
+func (sng *NodeGroup) hasFunction(notify bool, function string) bool {
+	sng.rwMu.RLock()
+	defer sng.rwMu.RUnlock()
+
+	functions := sng.callFunctionMap
+	if notify {
+		functions = sng.notifyFunctionMap
+	}
+	_, ok := functions[strings.ToLower(function)]
+	return ok
+}
+
 func (sng *NodeGroup) GetNodeCount() int {
 	sng.rwMu.RLock()
 	defer sng.rwMu.RUnlock()
@@ -102,17 +227,26 @@ func (sng *NodeGroup) GetNodeCount() int {
 	return len(sng.nodes)
 }
 
-type futureReciveRes chan *rpc2.Call
+type futureReciveRes struct {
+	receive chan *rpc2.Call
+	node    *NodeInfo
+	group   *NodeGroup
+}
 
 func make_faild_futrueRes(res *common.Response, err_code common.ErrCode) futureReciveRes {
 	receive := make(chan *rpc2.Call, 1)
 	res.Data.Err = err_code
 	receive <- &rpc2.Call{}
-	return receive
+	return futureReciveRes{receive: receive}
 }
 
 func (r futureReciveRes) Done() {
-	call := <-r
+	call := <-r.receive
+	if r.node != nil {
+		atomic.AddInt64(&r.node.inFlight, -1)
+		r.group.reportResult(r.node, call.Error == nil)
+	}
+
 	response, isok := call.Reply.(*common.Response)
 	if !isok {
 		return
@@ -129,17 +263,27 @@ func (sng *NodeGroup) Go(fromClient *rpc2.Client, req *common.Request,
 	sng.rwMu.RLock()
 	defer sng.rwMu.RUnlock()
 
-	if _, ok := sng.callFunctionMap[req.Method.Function]; !ok {
+	if _, ok := sng.callFunctionMap[strings.ToLower(req.Method.Function)]; !ok {
 		return make_faild_futrueRes(res, common.ErrNotFindCaller)
 	}
 
-	node := sng.getCallTagNode(fromClient, req.Method.Tag)
+	selector, ok := requestSelector(req)
+	if !ok {
+		return make_faild_futrueRes(res, common.ErrInvalidSelector)
+	}
+
+	node := sng.getCallTagNode(fromClient, selector, req, nil)
 	if node == nil {
 		return make_faild_futrueRes(res, common.ErrNotFindService)
 	}
 
-	return node.client.Go(common.MethodNodeCall, req, res,
-		make(chan *rpc2.Call, 1)).Done
+	atomic.AddInt64(&node.inFlight, 1)
+
+	return futureReciveRes{
+		receive: node.client.Go(common.MethodNodeCall, req, res, make(chan *rpc2.Call, 1)).Done,
+		node:    node,
+		group:   sng,
+	}
 }
 
 func (sng *NodeGroup) Call2(fromClient *rpc2.Client,
@@ -149,41 +293,143 @@ func (sng *NodeGroup) Call2(fromClient *rpc2.Client,
 }
 
 func (sng *NodeGroup) Call(fromClient *rpc2.Client, req *common.Request, res *common.Response) {
+	sng.CallContext(context.Background(), fromClient, req, res)
+}
+
+// 调用节点, ctx结束时放弃等待并返回ErrCallTimeout或ErrCallCanceled,
+// 失败时按重试策略换一个没有尝试过的节点重新调用
+func (sng *NodeGroup) CallContext(ctx context.Context, fromClient *rpc2.Client,
+	req *common.Request, res *common.Response) {
+	selector, ok := requestSelector(req)
+	if !ok {
+		res.Data.Err = common.ErrInvalidSelector
+		return
+	}
+	sng.callContext(ctx, fromClient, selector, req, res)
+}
+
+// 使用已经解析的tag选择器调用节点, 重试时不再重复解析
+func (sng *NodeGroup) callContext(ctx context.Context, fromClient *rpc2.Client, selector labelSelector,
+	req *common.Request, res *common.Response) {
+	function := strings.ToLower(req.Method.Function)
+
 	sng.rwMu.RLock()
-	defer sng.rwMu.RUnlock()
+	_, ok := sng.callFunctionMap[function]
+	_, idempotent := sng.idempotentFunctionMap[function]
+	sng.rwMu.RUnlock()
 
-	if _, ok := sng.callFunctionMap[strings.ToLower(req.Method.Function)]; !ok {
+	if !ok {
 		res.Data.Err = common.ErrNotFindCaller
 		return
 	}
 
-	node := sng.getCallTagNode(fromClient, req.Method.Tag)
-	if node == nil {
-		res.Data.Err = common.ErrNotFindService
-		return
+	tried := make(map[*NodeInfo]bool)
+	for attempt := 1; ; attempt++ {
+		node := func() *NodeInfo {
+			sng.rwMu.RLock()
+			defer sng.rwMu.RUnlock()
+
+			return sng.getCallTagNode(fromClient, selector, req, tried)
+		}()
+		if node == nil {
+			if attempt == 1 {
+				res.Data.Err = common.ErrNotFindService
+			}
+			return
+		}
+		tried[node] = true
+
+		*res = *sng.callNode(ctx, node, req)
+		if res.Data.Err == common.ErrOk || ctx.Err() != nil ||
+			!sng.canRetry(attempt, idempotent, res.Data.Err) {
+			return
+		}
+
+		sng.Info("#Call %s:%s retry, attempt:%d, err:%d",
+			req.Method.GetInstance(), req.Method.Function, attempt, res.Data.Err)
+	}
+}
+
+func (sng *NodeGroup) canRetry(attempt int, idempotent bool, code common.ErrCode) bool {
+	if attempt >= sng.retry.MaxAttempts {
+		return false
+	}
+	if sng.retry.IdempotentOnly && !idempotent {
+		return false
 	}
+	return sng.retry.IsRetryCode(code)
+}
 
-	err := node.client.Call(common.MethodNodeCall, req, res)
-	if err != nil {
-		sng.Error("#Call %s:%s srv:%s", req.Method.GetInstance(), req.Method.Function, err.Error())
+func (sng *NodeGroup) callNode(ctx context.Context, node *NodeInfo, req *common.Request) *common.Response {
+	res := &common.Response{}
+
+	atomic.AddInt64(&node.inFlight, 1)
+	defer atomic.AddInt64(&node.inFlight, -1)
+
+	// 超时后rpc2仍可能写入应答, 所以先写到临时对象
+	reply := &common.Response{}
+	call := node.client.Go(common.MethodNodeCall, req, reply, make(chan *rpc2.Call, 1))
+	select {
+	case <-call.Done:
+	case <-ctx.Done():
+		sng.Error("#Call %s:%s srv:%s", req.Method.GetInstance(), req.Method.Function, ctx.Err().Error())
+
+		// 只有超过截止时间才算节点失败, 调用方自己取消的不计入熔断
+		if ctx.Err() == context.DeadlineExceeded {
+			sng.reportResult(node, false)
+		} else {
+			node.breaker.OnCancel()
+		}
+		res.Data.Err = common.ContextErrCode(ctx.Err())
+		res.Data.ErrMsg = ctx.Err().Error()
+		return res
+	}
+
+	sng.reportResult(node, call.Error == nil)
+	if call.Error != nil {
+		sng.Error("#Call %s:%s srv:%s", req.Method.GetInstance(), req.Method.Function, call.Error.Error())
 
 		res.Data.Err = common.ErrCallFailed
-		return
+		return res
+	}
+
+	return reply
+}
+
+// 记录调用结果到节点熔断器
+func (sng *NodeGroup) reportResult(node *NodeInfo, success bool) {
+	atomic.AddInt64(&node.calls, 1)
+	if !success {
+		atomic.AddInt64(&node.failures, 1)
+	}
+
+	if state, changed := node.breaker.OnResult(success); changed {
+		sng.Info("breaker %s(%s) -> %s", node.RegisterData.GetKey(), node.RegisterData.Tag, state.String())
 	}
 }
 
 func (sng *NodeGroup) Notify(client *rpc2.Client, req *common.Request, res *common.Response) {
+	selector, ok := requestSelector(req)
+	if !ok {
+		res.Data.Err = common.ErrInvalidSelector
+		return
+	}
+	sng.notify(client, selector, req, res)
+}
+
+// 使用已经解析的tag选择器通知所有满足条件的节点
+func (sng *NodeGroup) notify(client *rpc2.Client, selector labelSelector, req *common.Request, res *common.Response) {
 	sng.rwMu.RLock()
 	defer sng.rwMu.RUnlock()
 
-	if _, ok := sng.notifyFunctionMap[req.Method.Function]; !ok {
+	if _, ok := sng.notifyFunctionMap[strings.ToLower(req.Method.Function)]; !ok {
 		res.Data.Err = common.ErrNotFindNotifier
 		return
 	}
 
 	for _, node := range sng.nodes {
-		if node != nil && node.client != client {
-			if req.Method.Tag == "" || req.Method.Tag == node.RegisterData.Tag {
+		if node != nil && node.client != client && !node.draining && !node.cordoned {
+			if selector.matches(&node.RegisterData) {
 				err := node.client.Notify(common.MethodNodeNotify, req)
 				if err != nil {
 					sng.Error("#Notify %s:%s srv:%s", req.Method.GetInstance(), req.Method.Function, err.Error())
@@ -194,28 +440,33 @@ func (sng *NodeGroup) Notify(client *rpc2.Client, req *common.Request, res *comm
 	}
 }
 
-func (sng *NodeGroup) getCallTagNode(fromClient *rpc2.Client, tag string) *NodeInfo {
-	length := int64(len(sng.nodes))
-	if length == 0 {
-		return nil
+// 选择一个节点, 跳过发起方和excluded中的节点, 指定tag时只在满足tag选择器的节点中选,
+// 返回的节点已经占用了熔断器的名额, 调用结束后需要reportResult
+func (sng *NodeGroup) getCallTagNode(fromClient *rpc2.Client, selector labelSelector,
+	req *common.Request, excluded map[*NodeInfo]bool) *NodeInfo {
+	candidates := make([]*NodeInfo, 0, len(sng.nodes))
+	for _, node := range sng.nodes {
+		if node.client == fromClient || node.draining || node.cordoned || excluded[node] || !node.breaker.Available() {
+			continue
+		}
+		if !selector.matches(&node.RegisterData) {
+			continue
+		}
+		candidates = append(candidates, node)
 	}
 
-	if tag == "" {
-		for i := int64(0); i < length; i++ {
-			atomic.AddInt64(&sng.index, 1)
-			atomic.CompareAndSwapInt64(&sng.index, length, 0)
-			index := sng.index % length
-			if sng.nodes[index].client != fromClient {
-				return sng.nodes[index]
-			}
+	// 选中后再占用熔断器的试探名额, 被并发的调用占满时换一个节点
+	for len(candidates) > 0 {
+		node := sng.balancer.Pick(candidates, req)
+		if node == nil || node.breaker.Allow() {
+			return node
 		}
-	} else {
-		for _, node := range sng.nodes {
-			if node.client != fromClient && node.RegisterData.Tag == tag {
-				return node
+		for i, candidate := range candidates {
+			if candidate == node {
+				candidates = append(candidates[:i], candidates[i+1:]...)
+				break
 			}
 		}
 	}
-
 	return nil
 }
@@ -0,0 +1,34 @@
+package rpc
+
+import (
+	"gitlab.forceup.in/zengliang/rpc2-center/common"
+	"gitlab.forceup.in/zengliang/rpc2-center/loger"
+	"testing"
+)
+
+// 方法名不区分大小写, 与注册时的大小写无关
+func TestNodeGroupFunctionCase(t *testing.T) {
+	sng := &NodeGroup{ILoger: &loger.MyLoger{}}
+	sng.Register(nil, &common.Register{
+		Service:      common.Service{Version: "v1", Name: "pay"},
+		CallerList:   []string{"Charge"},
+		NotifierList: []string{"Refunded"},
+	})
+
+	for _, function := range []string{"charge", "CHARGE"} {
+		res := &common.Response{}
+		sng.Go(nil, &common.Request{Method: common.NewMethod("v1", "pay", function)}, res).Done()
+		// 注册的节点是发起方自己, 能找到方法但没有可用节点
+		if res.Data.Err != common.ErrNotFindService {
+			t.Errorf("Go(%s): err = %d, want %d", function, res.Data.Err, common.ErrNotFindService)
+		}
+	}
+
+	for _, function := range []string{"refunded", "REFUNDED"} {
+		res := &common.Response{}
+		sng.Notify(nil, &common.Request{Method: common.NewMethod("v1", "pay", function)}, res)
+		if res.Data.Err != common.ErrOk {
+			t.Errorf("Notify(%s): err = %d, want %d", function, res.Data.Err, common.ErrOk)
+		}
+	}
+}
@@ -0,0 +1,518 @@
+package rpc
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/tls"
+	"encoding/hex"
+	"fmt"
+	"github.com/zl03jsj/rpc2"
+	"gitlab.forceup.in/zengliang/rpc2-center/common"
+	"net"
+	"strings"
+	"sync"
+	"time"
+)
+
+// 对方通过认证之前最多暂存的注册事件数
+const maxPeerPendingEvents = 10000
+
+type (
+	// 与另一个center的连接, 保存注册在对方的节点
+	peerLink struct {
+		addr   string // 主动连接时为对方地址, 对方连接过来时为空
+		client *rpc2.Client
+		center common.Register
+
+		rwMu    sync.RWMutex
+		nodes   map[string]common.Register // 节点id -> 注册信息
+		synced  bool                       // 是否已经收到对方的节点列表, 收到之前对方已经通过认证
+		pending []common.RegistryEvent     // 对方通过认证之前收到的注册事件, 认证后在节点列表之后重放
+		dropped bool                       // 暂存的事件超过maxPeerPendingEvents
+	}
+
+	// 集群中的其他center
+	peerGroup struct {
+		rwMu  sync.RWMutex
+		links map[*rpc2.Client]*peerLink
+	}
+)
+
+// center在集群中的唯一id
+func newCenterId(srv common.Service) string {
+	b := make([]byte, 4)
+	rand.Read(b)
+	return srv.GetInstance() + "-" + hex.EncodeToString(b)
+}
+
+func newPeerGroup() *peerGroup {
+	return &peerGroup{links: make(map[*rpc2.Client]*peerLink)}
+}
+
+// 添加连接后就开始接收对方的注册事件, 对方通过认证并调用sync之前事件只会暂存
+func (pg *peerGroup) add(client *rpc2.Client, addr string) *peerLink {
+	link := &peerLink{
+		addr:   addr,
+		client: client,
+		nodes:  make(map[string]common.Register),
+	}
+
+	pg.rwMu.Lock()
+	defer pg.rwMu.Unlock()
+	pg.links[client] = link
+	return link
+}
+
+func (pg *peerGroup) remove(client *rpc2.Client) *peerLink {
+	pg.rwMu.Lock()
+	defer pg.rwMu.Unlock()
+
+	link, ok := pg.links[client]
+	if ok {
+		delete(pg.links, client)
+	}
+	return link
+}
+
+func (pg *peerGroup) get(client *rpc2.Client) *peerLink {
+	pg.rwMu.RLock()
+	defer pg.rwMu.RUnlock()
+	return pg.links[client]
+}
+
+func (pg *peerGroup) getAll() []*peerLink {
+	pg.rwMu.RLock()
+	defer pg.rwMu.RUnlock()
+
+	links := make([]*peerLink, 0, len(pg.links))
+	for _, link := range pg.links {
+		links = append(links, link)
+	}
+	return links
+}
+
+// 注册在所有peer上的节点, 两个center互相配置为peer时有两个连接, 按节点id去掉重复的节点
+func (pg *peerGroup) getNodes() []common.Register {
+	nodes := []common.Register{}
+	exists := make(map[string]bool)
+	for _, link := range pg.getAll() {
+		for _, reg := range link.getNodes() {
+			if !exists[reg.Id] {
+				exists[reg.Id] = true
+				nodes = append(nodes, reg)
+			}
+		}
+	}
+	return nodes
+}
+
+// 注册了req目标服务的center, 跳过请求已经经过的center
+func (pg *peerGroup) find(req *common.Request, selector labelSelector) []*peerLink {
+	hops := getHops(req)
+	links := []*peerLink{}
+	for _, link := range pg.getAll() {
+		if hops[link.id()] {
+			continue
+		}
+		if link.hasService(req.Method.GetKey(), selector) {
+			links = append(links, link)
+		}
+	}
+	return links
+}
+
+// 对方通过认证后设置节点列表, 再按顺序重放暂存的事件, 节点id不会重复使用, 所以重放的结果与顺序处理一致,
+// 返回对方的所有节点, 暂存时丢弃过事件则返回false
+func (link *peerLink) sync(snapshot *common.PeerSnapshot) ([]common.Register, bool) {
+	link.rwMu.Lock()
+	defer link.rwMu.Unlock()
+
+	if link.dropped {
+		return nil, false
+	}
+
+	link.center = snapshot.Center
+	for _, reg := range snapshot.Nodes {
+		link.nodes[reg.Id] = reg
+	}
+	for i := range link.pending {
+		link.apply(&link.pending[i])
+	}
+	link.synced = true
+	link.pending = nil
+
+	nodes := make([]common.Register, 0, len(link.nodes))
+	for _, reg := range link.nodes {
+		nodes = append(nodes, reg)
+	}
+	return nodes, true
+}
+
+// 处理对方的注册事件, 对方还没有通过认证时暂存并返回false
+func (link *peerLink) onEvent(event *common.RegistryEvent) bool {
+	link.rwMu.Lock()
+	defer link.rwMu.Unlock()
+
+	if !link.synced {
+		if len(link.pending) < maxPeerPendingEvents {
+			link.pending = append(link.pending, *event)
+		} else {
+			link.dropped = true
+		}
+		return false
+	}
+	link.apply(event)
+	return true
+}
+
+func (link *peerLink) apply(event *common.RegistryEvent) {
+	switch event.Type {
+	case common.EventRegister:
+		link.nodes[event.Node.Id] = event.Node
+	case common.EventUnRegister:
+		delete(link.nodes, event.Node.Id)
+	}
+}
+
+// 注册在对方的节点
+func (link *peerLink) getNodes() []common.Register {
+	link.rwMu.RLock()
+	defer link.rwMu.RUnlock()
+
+	nodes := make([]common.Register, 0, len(link.nodes))
+	for _, reg := range link.nodes {
+		nodes = append(nodes, reg)
+	}
+	return nodes
+}
+
+// 对方center的注册信息, 对方还没有通过认证时返回false
+func (link *peerLink) getCenter() (common.Register, bool) {
+	link.rwMu.RLock()
+	defer link.rwMu.RUnlock()
+	return link.center, link.synced
+}
+
+// 对方center的id
+func (link *peerLink) id() string {
+	link.rwMu.RLock()
+	defer link.rwMu.RUnlock()
+	return link.center.Id
+}
+
+func (link *peerLink) hasService(srvKey string, selector labelSelector) bool {
+	link.rwMu.RLock()
+	defer link.rwMu.RUnlock()
+
+	for _, reg := range link.nodes {
+		if reg.GetKey() == srvKey && selector.matches(&reg) {
+			return true
+		}
+	}
+	return false
+}
+
+// 请求经过的center
+func getHops(req *common.Request) map[string]bool {
+	hops := make(map[string]bool)
+	if s, ok := req.Context[common.ContextKeyHops].(string); ok && s != "" {
+		for _, id := range strings.Split(s, ",") {
+			hops[id] = true
+		}
+	}
+	return hops
+}
+
+// 只有通过认证的peer转发的请求才带有经过的center, 其他来源的请求清除
+func clearHops(req *common.Request) {
+	delete(req.Context, common.ContextKeyHops)
+}
+
+func addHop(req *common.Request, id string) {
+	if req.Context == nil {
+		req.Context = make(common.Context)
+	}
+	if s, ok := req.Context[common.ContextKeyHops].(string); ok && s != "" {
+		req.Context[common.ContextKeyHops] = s + "," + id
+	} else {
+		req.Context[common.ContextKeyHops] = id
+	}
+}
+
+// 连接配置中的所有peer, 断开后按退避策略重连
+func (c *Center) startPeers(ctx context.Context) {
+	for _, addr := range c.cfgCenter.Peers {
+		c.loopWg.Add(1)
+		go c.loopToPeer(ctx, addr)
+	}
+}
+
+func (c *Center) loopToPeer(ctx context.Context, addr string) {
+	c.Info("peer %s loop start...", addr)
+
+	defer func() {
+		c.loopWg.Done()
+		c.Info("peer %s loop stop...", addr)
+	}()
+
+	backoff := common.BackoffConfig{}.NewBackoff()
+	for {
+		if err := c.runPeer(ctx, addr); err != nil {
+			c.Error("peer %s: %s", addr, err.Error())
+		} else {
+			backoff.Reset()
+		}
+
+		if ctx.Err() != nil {
+			return
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(backoff.Next()):
+		}
+	}
+}
+
+// 连接并加入peer, 直到连接断开或ctx结束,
+// 对方通过认证之前收到的注册事件只暂存, 不会修改路由
+func (c *Center) runPeer(ctx context.Context, addr string) error {
+	client, err := c.connectToPeer(addr)
+	if err != nil {
+		return err
+	}
+	defer client.Close()
+
+	c.handlePeer(client)
+	go client.Run()
+
+	link := c.peers.add(client, addr)
+	defer c.removePeer(client)
+
+	snapshot := &common.PeerSnapshot{}
+	if err = client.Call(common.MethodCenterPeerJoin, c.peerSnapshot(), snapshot); err != nil {
+		return err
+	}
+	if err = c.checkPeer(&snapshot.Center); err != nil {
+		return err
+	}
+
+	nodes, ok := link.sync(snapshot)
+	if !ok {
+		return fmt.Errorf("too many events before join")
+	}
+	c.publishWatchEvents(common.EventRegister, nodes)
+	c.Info("join peer %s(%s), nodes=%d", addr, snapshot.Center.Id, len(snapshot.Nodes))
+
+	select {
+	case <-ctx.Done():
+	case <-client.DisconnectNotify():
+		c.Error("peer %s disconnect...", addr)
+	}
+
+	return nil
+}
+
+func (c *Center) connectToPeer(addr string) (*rpc2.Client, error) {
+	var conn net.Conn
+	var err error
+	if c.cfgCenter.TLS.Enable {
+		var tlsConf *tls.Config
+		tlsConf, err = c.cfgCenter.TLS.ClientConfig(addr)
+		if err != nil {
+			return nil, err
+		}
+		conn, err = tls.Dial("tcp", addr, tlsConf)
+	} else {
+		conn, err = net.Dial("tcp", addr)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	return rpc2.NewClient(conn), nil
+}
+
+// peer之间的连接是双向的, 主动连接的一方也要处理对方发来的请求
+func (c *Center) handlePeer(client *rpc2.Client) {
+	client.Handle(common.MethodCenterPeerEvent, c.byPeerEvent)
+	client.Handle(common.MethodCenterPeerCall, c.byPeerCall)
+	client.Handle(common.MethodCenterPeerNotify, c.byPeerNotify)
+}
+
+// center自己和注册在本center上的节点
+func (c *Center) peerSnapshot() *common.PeerSnapshot {
+	snapshot := &common.PeerSnapshot{Center: c.regData}
+	snapshot.Center.Sign(common.RegisterAuthHmac, c.cfgCenter.PeerSecret)
+
+	c.rwMu.RLock()
+	defer c.rwMu.RUnlock()
+
+	for _, nodeGroup := range c.verNameMapNodeGroup {
+		for _, reg := range nodeGroup.GetNodes() {
+			if reg.Id != c.id {
+				snapshot.Nodes = append(snapshot.Nodes, reg)
+			}
+		}
+	}
+	return snapshot
+}
+
+// 断开peer, 对方的节点全部视为注销
+func (c *Center) removePeer(client *rpc2.Client) {
+	link := c.peers.remove(client)
+	if link == nil {
+		return
+	}
+
+	c.Info("peer %s left", link.id())
+	c.publishWatchEvents(common.EventUnRegister, link.getNodes())
+}
+
+// 通知所有peer本center上的节点注册或注销
+func (c *Center) publishPeerEvent(eventType string, reg *common.Register) {
+	event := &common.RegistryEvent{Type: eventType, Node: *reg}
+	for _, link := range c.peers.getAll() {
+		if err := link.client.Notify(common.MethodCenterPeerEvent, event); err != nil {
+			c.Error("publish peer event to %s: %s", link.id(), err.Error())
+		}
+	}
+}
+
+// 校验对方center的token, 没有配置peer_secret时拒绝
+func (c *Center) checkPeer(center *common.Register) error {
+	if c.cfgCenter.PeerSecret == "" {
+		return common.NewCodeError(common.ErrRegisterDenied, "peer_secret not configured")
+	}
+	if err := checkHmacToken(center, c.cfgCenter.PeerSecret, c.cfgCenter.RegisterAuth.MaxSkew); err != nil {
+		return err
+	}
+	center.Token = ""
+	return nil
+}
+
+func (c *Center) byPeerJoin(client *rpc2.Client, join *common.PeerSnapshot, res *common.PeerSnapshot) error {
+	if err := c.checkPeer(&join.Center); err != nil {
+		c.Error("peer %s join denied: %s", join.Center.Id, err.Error())
+		return err
+	}
+
+	nodes, _ := c.peers.add(client, "").sync(join)
+	c.publishWatchEvents(common.EventRegister, nodes)
+	c.Info("peer %s joined, nodes=%d", join.Center.Id, len(join.Nodes))
+
+	*res = *c.peerSnapshot()
+	return nil
+}
+
+func (c *Center) byPeerEvent(client *rpc2.Client, event *common.RegistryEvent, res *string) error {
+	link := c.peers.get(client)
+	if link == nil {
+		return nil
+	}
+
+	c.Debug("peer %s %s %s", link.id(), event.Type, event.Node.GetInstance())
+	if link.onEvent(event) {
+		c.publishWatchEvent(event)
+	}
+	return nil
+}
+
+// peer转发过来的请求的context, 对方通过认证后才信任请求中的调用方,
+// 调用方为对方center自己时不检查调用权限
+func (c *Center) peerRequestContext(link *peerLink, req *common.Request) context.Context {
+	ctx := context.Background()
+	center, ok := link.getCenter()
+	if !ok {
+		setCaller(req, common.CallerAnonymous)
+		clearHops(req)
+	} else if getCaller(req) == center.GetKey() {
+		ctx = withInternalCall(ctx)
+	}
+	return ctx
+}
+
+// peer转发过来的请求, 调用方身份已经由peer设置
+func (c *Center) byPeerCall(client *rpc2.Client, req *common.Request, res *common.Response) error {
+	if !c.beginRequest() {
+		res.Data.Err = common.ErrCenterClosing
+		return nil
+	}
+	defer c.wg.Done()
+
+	link := c.peers.get(client)
+	if link == nil {
+		res.Data.Err = common.ErrRegisterDenied
+		return nil
+	}
+
+	c.callFunction(c.peerRequestContext(link, req), nil, req, res)
+	return nil
+}
+
+func (c *Center) byPeerNotify(client *rpc2.Client, req *common.Request, res *common.Response) error {
+	if !c.beginRequest() {
+		res.Data.Err = common.ErrCenterClosing
+		return nil
+	}
+	defer c.wg.Done()
+
+	link := c.peers.get(client)
+	if link == nil {
+		res.Data.Err = common.ErrRegisterDenied
+		return nil
+	}
+
+	c.notifyFunction(c.peerRequestContext(link, req), nil, req, res)
+	return nil
+}
+
+// 目标服务只注册在其他center上时转发调用, 没有可用的peer时返回false
+func (c *Center) forwardCall(ctx context.Context, selector labelSelector, req *common.Request, res *common.Response) bool {
+	links := c.peers.find(req, selector)
+	if len(links) == 0 {
+		return false
+	}
+	addHop(req, c.id)
+
+	var err error
+	for _, link := range links {
+		reply := &common.Response{}
+		call := link.client.Go(common.MethodCenterPeerCall, req, reply, make(chan *rpc2.Call, 1))
+		select {
+		case <-call.Done:
+		case <-ctx.Done():
+			res.Data.Err = common.ContextErrCode(ctx.Err())
+			res.Data.ErrMsg = ctx.Err().Error()
+			return true
+		}
+
+		if err = call.Error; err == nil {
+			*res = *reply
+			return true
+		}
+		c.Error("#Call %s:%s peer:%s", req.Method.GetInstance(), req.Method.Function, err.Error())
+	}
+
+	res.Data.Err = common.ErrCallFailed
+	res.Data.ErrMsg = fmt.Sprintf("forward to peer failed: %s", err.Error())
+	return true
+}
+
+// 目标服务只注册在其他center上时, 转发给所有注册了该服务的center
+func (c *Center) forwardNotify(selector labelSelector, req *common.Request, res *common.Response) bool {
+	links := c.peers.find(req, selector)
+	if len(links) == 0 {
+		return false
+	}
+	addHop(req, c.id)
+
+	for _, link := range links {
+		if err := link.client.Notify(common.MethodCenterPeerNotify, req); err != nil {
+			c.Error("#Notify %s:%s peer:%s", req.Method.GetInstance(), req.Method.Function, err.Error())
+			res.Data.Err = common.ErrCallFailed
+		}
+	}
+	return true
+}
@@ -0,0 +1,268 @@
+package rpc
+
+import (
+	"context"
+	"github.com/zl03jsj/rpc2"
+	"gitlab.forceup.in/zengliang/rpc2-center/common"
+	"gitlab.forceup.in/zengliang/rpc2-center/loger"
+	"net"
+	"sort"
+	"testing"
+	"time"
+)
+
+func newPeerEvent(eventType, id string) common.RegistryEvent {
+	return common.RegistryEvent{Type: eventType, Node: common.Register{
+		Service: common.Service{Version: "v1", Name: "pay"},
+		Id:      id,
+	}}
+}
+
+func nodeIds(regs []common.Register) []string {
+	ids := []string{}
+	for _, reg := range regs {
+		ids = append(ids, reg.Id)
+	}
+	sort.Strings(ids)
+	return ids
+}
+
+// 对方通过认证之前的事件不修改节点列表, 认证后在节点列表之后按顺序重放
+func TestPeerLinkSync(t *testing.T) {
+	tests := []struct {
+		name     string
+		pending  []common.RegistryEvent
+		snapshot []string
+		want     []string
+	}{
+		{"snapshot only", nil, []string{"a", "b"}, []string{"a", "b"}},
+		{"register before join", []common.RegistryEvent{newPeerEvent(common.EventRegister, "c")},
+			[]string{"a"}, []string{"a", "c"}},
+		{"unregister before join", []common.RegistryEvent{newPeerEvent(common.EventUnRegister, "a")},
+			[]string{"a", "b"}, []string{"b"}},
+		{"register and unregister before join", []common.RegistryEvent{
+			newPeerEvent(common.EventRegister, "c"),
+			newPeerEvent(common.EventUnRegister, "c"),
+		}, []string{"a"}, []string{"a"}},
+	}
+
+	for _, tt := range tests {
+		link := newPeerGroup().add(nil, "")
+		for i := range tt.pending {
+			if link.onEvent(&tt.pending[i]) {
+				t.Errorf("%s: event applied before join", tt.name)
+			}
+		}
+		if nodes := link.getNodes(); len(nodes) != 0 {
+			t.Errorf("%s: %d nodes before join", tt.name, len(nodes))
+		}
+
+		snapshot := &common.PeerSnapshot{}
+		for _, id := range tt.snapshot {
+			snapshot.Nodes = append(snapshot.Nodes, newPeerEvent(common.EventRegister, id).Node)
+		}
+		nodes, ok := link.sync(snapshot)
+		if !ok {
+			t.Fatalf("%s: sync failed", tt.name)
+		}
+		if got := nodeIds(nodes); !equalStrings(got, tt.want) {
+			t.Errorf("%s: nodes = %v, want %v", tt.name, got, tt.want)
+		}
+
+		event := newPeerEvent(common.EventRegister, "z")
+		if !link.onEvent(&event) {
+			t.Errorf("%s: event not applied after join", tt.name)
+		}
+	}
+}
+
+func TestPeerLinkPendingLimit(t *testing.T) {
+	link := newPeerGroup().add(nil, "")
+	for i := 0; i <= maxPeerPendingEvents; i++ {
+		event := newPeerEvent(common.EventRegister, "a")
+		link.onEvent(&event)
+	}
+
+	if _, ok := link.sync(&common.PeerSnapshot{}); ok {
+		t.Errorf("sync should fail after dropping events")
+	}
+	if _, ok := link.getCenter(); ok {
+		t.Errorf("link should not be authenticated")
+	}
+}
+
+func equalStrings(a, b []string) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if a[i] != b[i] {
+			return false
+		}
+	}
+	return true
+}
+
+func TestRequestHops(t *testing.T) {
+	req := &common.Request{}
+	if len(getHops(req)) != 0 {
+		t.Fatalf("new request should have no hops")
+	}
+
+	addHop(req, "c1")
+	addHop(req, "c2")
+	if hops := getHops(req); len(hops) != 2 || !hops["c1"] || !hops["c2"] {
+		t.Errorf("hops = %v", hops)
+	}
+
+	clearHops(req)
+	if len(getHops(req)) != 0 {
+		t.Errorf("hops should be cleared")
+	}
+}
+
+// 转发时跳过请求已经经过的center, 防止在center之间循环转发
+func TestPeerGroupFind(t *testing.T) {
+	pg := newPeerGroup()
+	for _, id := range []string{"c1", "c2", "c3"} {
+		snapshot := &common.PeerSnapshot{Center: common.Register{Id: id}}
+		if id != "c3" {
+			snapshot.Nodes = []common.Register{{Service: common.Service{Version: "v1", Name: "pay", Tag: id}, Id: id + "-1"}}
+		}
+		pg.add(&rpc2.Client{}, "").sync(snapshot)
+	}
+
+	tests := []struct {
+		hops string
+		tag  string
+		want []string
+	}{
+		{"", "", []string{"c1", "c2"}},
+		{"c1", "", []string{"c2"}},
+		{"c1,c2", "", []string{}},
+		{"", "c2", []string{"c2"}},
+		{"", "tag in (c1,c3)", []string{"c1"}},
+	}
+
+	for _, tt := range tests {
+		req := &common.Request{Method: common.NewMethod("v1", "pay", "charge")}
+		req.Method.Tag = tt.tag
+		if tt.hops != "" {
+			req.Context = common.Context{common.ContextKeyHops: tt.hops}
+		}
+
+		got := []string{}
+		selector, err := parseSelector(tt.tag)
+		if err != nil {
+			t.Fatal(err)
+		}
+		for _, link := range pg.find(req, selector) {
+			got = append(got, link.id())
+		}
+		sort.Strings(got)
+		if !equalStrings(got, tt.want) {
+			t.Errorf("hops %q tag %q: links = %v, want %v", tt.hops, tt.tag, got, tt.want)
+		}
+	}
+}
+
+// 两个center互相配置为peer时, 两个连接上的节点相同
+func TestPeerGroupGetNodes(t *testing.T) {
+	pg := newPeerGroup()
+	snapshot := &common.PeerSnapshot{
+		Center: common.Register{Id: "c1"},
+		Nodes: []common.Register{
+			newPeerEvent(common.EventRegister, "c1-1").Node,
+			newPeerEvent(common.EventRegister, "c1-2").Node,
+		},
+	}
+	pg.add(&rpc2.Client{}, "127.0.0.1:7081").sync(snapshot)
+	pg.add(&rpc2.Client{}, "").sync(snapshot)
+
+	if got := nodeIds(pg.getNodes()); !equalStrings(got, []string{"c1-1", "c1-2"}) {
+		t.Errorf("nodes = %v", got)
+	}
+}
+
+// 本机上一个空闲的端口
+func freeAddr(t *testing.T) string {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer l.Close()
+	return l.Addr().String()
+}
+
+// 等待cond满足, 超时后测试失败
+func waitFor(t *testing.T, what string, cond func() bool) {
+	deadline := time.Now().Add(time.Second * 5)
+	for !cond() {
+		if time.Now().After(deadline) {
+			t.Fatalf("timeout waiting for %s", what)
+		}
+		time.Sleep(time.Millisecond * 20)
+	}
+}
+
+func startTestCenter(t *testing.T, tag string, peers ...string) (*Center, string) {
+	addr := freeAddr(t)
+	conf := common.ConfigCenter{
+		Service:    common.Service{Version: "v1", Name: "center", Tag: tag},
+		RpcPort:    addr,
+		HttpPort:   freeAddr(t),
+		PeerSecret: "secret",
+		Peers:      peers,
+	}
+	c, err := NewCenter(conf, "", &loger.MyLoger{}, nil, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	StartCenter(context.Background(), c)
+	return c, addr
+}
+
+func startTestNode(t *testing.T, name, centerAddr string, register func(ag *ApiInfoGroup)) *Node {
+	conf := common.ConfigNode{Service: common.Service{Version: "v1", Name: name}, RpcAddr: centerAddr}
+	n, err := NewNode(conf, "", &loger.MyLoger{}, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if register != nil {
+		register(n.GetApiGroup())
+	}
+	StartNode(context.Background(), n)
+	waitFor(t, name+" registered", func() bool { return n.getClient() != nil })
+	return n
+}
+
+// 服务只注册在c1上时, c2把调用转发给c1并记录经过的center, 调用方自己设置的hops被清除
+func TestPeerForward(t *testing.T) {
+	c1, addr1 := startTestCenter(t, "c1")
+	defer c1.Shutdown(context.Background())
+	c2, addr2 := startTestCenter(t, "c2", addr1)
+	defer c2.Shutdown(context.Background())
+
+	hops := make(chan interface{}, 1)
+	pay := startTestNode(t, "pay", addr1, func(ag *ApiInfoGroup) {
+		ag.RegisterCaller("ping", func(req *common.Request, res *common.Response) {
+			hops <- req.Context[common.ContextKeyHops]
+			res.SetOkResult("pong")
+		})
+	})
+	defer StopNode(pay)
+	waitFor(t, "peer sync", func() bool { return c2.hasService("v1.pay") })
+
+	cli := startTestNode(t, "cli", addr2, nil)
+	defer StopNode(cli)
+
+	var out string
+	err := cli.Invoke(context.Background(), common.NewMethod("v1", "pay", "ping"), nil, &out,
+		WithContext(common.ContextKeyHops, c1.id))
+	if err != nil || out != "pong" {
+		t.Fatalf("Invoke() = %q, %v", out, err)
+	}
+	if got := <-hops; got != c2.id {
+		t.Errorf("hops = %v, want %s", got, c2.id)
+	}
+}
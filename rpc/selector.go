@@ -0,0 +1,166 @@
+package rpc
+
+import (
+	"fmt"
+	"gitlab.forceup.in/zengliang/rpc2-center/common"
+	"regexp"
+	"strings"
+)
+
+const (
+	selectorEquals = iota
+	selectorNotEquals
+	selectorIn
+	selectorNotIn
+	selectorExists
+	selectorNotExists
+)
+
+var (
+	selectorKeyRegexp = regexp.MustCompile(`^[A-Za-z0-9_.\-/]+$`)
+	selectorSetRegexp = regexp.MustCompile(`^(\S+)\s+(in|notin)\s*\((.*)\)$`)
+)
+
+type (
+	selectorRequirement struct {
+		key    string
+		op     int
+		values map[string]bool
+	}
+
+	// 节点标签选择器, 多个条件用逗号分隔, 需要全部满足:
+	// k=v, k==v, k!=v, k in (v1,v2), k notin (v1,v2), k(存在), !k(不存在)
+	// 标签为tag/version/name和Register.Meta, Register.Env.
+	// 不包含操作符的单个值按旧的方式与tag比较
+	labelSelector []selectorRequirement
+)
+
+func parseSelector(s string) (labelSelector, error) {
+	s = strings.TrimSpace(s)
+	if s == "" {
+		return nil, nil
+	}
+	if !strings.ContainsAny(s, "=!(,") && !strings.Contains(s, " ") {
+		return labelSelector{{key: "tag", op: selectorEquals, values: map[string]bool{s: true}}}, nil
+	}
+
+	selector := labelSelector{}
+	for _, item := range splitSelector(s) {
+		r, err := parseRequirement(strings.TrimSpace(item))
+		if err != nil {
+			return nil, err
+		}
+		selector = append(selector, r)
+	}
+	return selector, nil
+}
+
+// 按逗号分隔, 括号中的逗号不分隔
+func splitSelector(s string) []string {
+	items := []string{}
+	depth, start := 0, 0
+	for i, ch := range s {
+		switch ch {
+		case '(':
+			depth++
+		case ')':
+			depth--
+		case ',':
+			if depth == 0 {
+				items = append(items, s[start:i])
+				start = i + 1
+			}
+		}
+	}
+	return append(items, s[start:])
+}
+
+func parseRequirement(item string) (selectorRequirement, error) {
+	r := selectorRequirement{values: make(map[string]bool)}
+
+	if m := selectorSetRegexp.FindStringSubmatch(item); m != nil {
+		r.key = m[1]
+		if r.op = selectorIn; m[2] == "notin" {
+			r.op = selectorNotIn
+		}
+		for _, v := range strings.Split(m[3], ",") {
+			if v = strings.TrimSpace(v); v != "" {
+				r.values[v] = true
+			}
+		}
+	} else if strings.HasPrefix(item, "!") && !strings.Contains(item, "=") {
+		r.key, r.op = strings.TrimSpace(item[1:]), selectorNotExists
+	} else if i := strings.Index(item, "!="); i >= 0 {
+		r.key, r.op = strings.TrimSpace(item[:i]), selectorNotEquals
+		r.values[strings.TrimSpace(item[i+2:])] = true
+	} else if i := strings.Index(item, "="); i >= 0 {
+		r.key, r.op = strings.TrimSpace(item[:i]), selectorEquals
+		r.values[strings.TrimSpace(strings.TrimPrefix(item[i+1:], "="))] = true
+	} else {
+		r.key, r.op = item, selectorExists
+	}
+
+	if !selectorKeyRegexp.MatchString(r.key) {
+		return r, fmt.Errorf("invalid selector %q", item)
+	}
+	return r, nil
+}
+
+// 节点标签, 内置标签优先, 然后是Meta, Env
+func nodeLabel(reg *common.Register, key string) (string, bool) {
+	switch key {
+	case "tag":
+		return reg.Tag, true
+	case "version":
+		return reg.Version, true
+	case "name":
+		return reg.Name, true
+	}
+	if v, ok := reg.Meta[key]; ok {
+		return v, true
+	}
+	v, ok := reg.Env[key]
+	return v, ok
+}
+
+func (selector labelSelector) matches(reg *common.Register) bool {
+	for _, r := range selector {
+		v, ok := nodeLabel(reg, r.key)
+		switch r.op {
+		case selectorEquals, selectorIn:
+			if !ok || !r.values[v] {
+				return false
+			}
+		case selectorNotEquals, selectorNotIn:
+			if ok && r.values[v] {
+				return false
+			}
+		case selectorExists:
+			if !ok {
+				return false
+			}
+		case selectorNotExists:
+			if ok {
+				return false
+			}
+		}
+	}
+	return true
+}
+
+// 解析请求的tag选择器, 无效时设置错误码并返回false
+func checkSelector(req *common.Request, res *common.Response) (labelSelector, bool) {
+	selector, err := parseSelector(req.Method.Tag)
+	if err != nil {
+		res.Data.Err = common.ErrInvalidSelector
+		res.Data.ErrMsg = err.Error()
+		return nil, false
+	}
+	return selector, true
+}
+
+// 请求的tag选择器, 选择器无效时返回false
+func requestSelector(req *common.Request) (labelSelector, bool) {
+	selector, err := parseSelector(req.Method.Tag)
+	return selector, err == nil
+}
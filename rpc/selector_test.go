@@ -0,0 +1,78 @@
+package rpc
+
+import (
+	"gitlab.forceup.in/zengliang/rpc2-center/common"
+	"testing"
+)
+
+func TestParseSelector(t *testing.T) {
+	tests := []struct {
+		in    string
+		count int
+		ok    bool
+	}{
+		{"", 0, true},
+		{"canary", 1, true},
+		{"zone=a", 1, true},
+		{"zone==a,env!=prod", 2, true},
+		{"zone in (a, b),gpu,!draining", 3, true},
+		{"zone notin (a,b)", 1, true},
+		{"zo ne=a", 0, false},
+		{"zone=a,=b", 0, false},
+	}
+
+	for _, tt := range tests {
+		selector, err := parseSelector(tt.in)
+		if (err == nil) != tt.ok {
+			t.Errorf("parseSelector(%q) err = %v, want ok %v", tt.in, err, tt.ok)
+			continue
+		}
+		if err == nil && len(selector) != tt.count {
+			t.Errorf("parseSelector(%q) has %d requirements, want %d", tt.in, len(selector), tt.count)
+		}
+	}
+}
+
+func TestSelectorMatches(t *testing.T) {
+	reg := &common.Register{
+		Service: common.Service{Version: "v1", Name: "pay", Tag: "canary"},
+		Meta:    map[string]string{"zone": "a", "gpu": "1"},
+		Env:     map[string]string{"ENV": "prod"},
+	}
+
+	tests := []struct {
+		selector string
+		want     bool
+	}{
+		{"", true},
+		{"canary", true},
+		{"stable", false},
+		{"tag=canary", true},
+		{"version=v1,name=pay", true},
+		{"zone=a", true},
+		{"zone=b", false},
+		{"zone!=b", true},
+		{"region!=b", true},
+		{"zone in (a,b)", true},
+		{"zone in (b,c)", false},
+		{"zone notin (b,c)", true},
+		{"region notin (a)", true},
+		{"gpu", false}, // 单个值与tag比较
+		{"gpu,zone=a", true},
+		{"region,zone=a", false},
+		{"!region", true},
+		{"!gpu", false},
+		{"ENV=prod", true},
+		{"zone=a,ENV=test", false},
+	}
+
+	for _, tt := range tests {
+		selector, err := parseSelector(tt.selector)
+		if err != nil {
+			t.Fatalf("parseSelector(%q): %s", tt.selector, err.Error())
+		}
+		if got := selector.matches(reg); got != tt.want {
+			t.Errorf("%q matches = %v, want %v", tt.selector, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,51 @@
+package rpc
+
+import (
+	"gitlab.forceup.in/zengliang/rpc2-center/common"
+	"gitlab.forceup.in/zengliang/rpc2-center/tracing"
+	"net/http"
+	"strings"
+)
+
+// 开始一个span, 并把它作为请求的trace context继续传递, 返回的函数在请求结束时调用
+func startRequestSpan(tracer *tracing.Tracer, kind string, parent tracing.SpanContext,
+	req *common.Request, res *common.Response) (*tracing.Span, func()) {
+	span := tracer.StartSpan(kind+" "+req.Method.GetFunctionKey(), parent)
+	if span == nil {
+		return nil, func() {}
+	}
+
+	span.SetAttribute("tag", req.Method.Tag)
+	tracing.Inject(req, span.Context())
+
+	return span, func() {
+		if res.Data.Err != common.ErrOk {
+			span.SetError("%d:%s", res.Data.Err, res.Data.ErrMsg)
+		}
+		span.Finish()
+	}
+}
+
+// 从http header中取出需要随请求传递的上下文, X-Rpc-Ctx-前缀的header转换为小写下划线的key,
+// 不能设置center保留的key
+func httpRequestContext(req *http.Request) common.Context {
+	ctx := common.Context{}
+	for name, values := range req.Header {
+		if !strings.HasPrefix(name, common.HeaderContextPrefix) || len(values) == 0 {
+			continue
+		}
+		key := strings.ToLower(strings.Replace(strings.TrimPrefix(name, common.HeaderContextPrefix), "-", "_", -1))
+		switch key {
+		case common.ContextKeyCaller, common.ContextKeyHops, common.ContextKeyDeadline:
+			continue
+		}
+		ctx[key] = values[0]
+	}
+
+	for _, key := range []string{common.ContextKeyTraceParent, common.ContextKeyTraceState} {
+		if v := req.Header.Get(key); v != "" {
+			ctx[key] = v
+		}
+	}
+	return ctx
+}
@@ -0,0 +1,83 @@
+package rpc
+
+import (
+	"context"
+	"gitlab.forceup.in/zengliang/rpc2-center/common"
+	"gitlab.forceup.in/zengliang/rpc2-center/loger"
+	"gitlab.forceup.in/zengliang/rpc2-center/tracing"
+	"net/http/httptest"
+	"testing"
+)
+
+// 保留的key不能通过header设置
+func TestHttpRequestContext(t *testing.T) {
+	req := httptest.NewRequest("POST", "/call/v1/pay/charge", nil)
+	req.Header.Set(common.HeaderContextPrefix+"User-Id", "1")
+	req.Header.Set(common.HeaderContextPrefix+"Caller", "v1.admin")
+	req.Header.Set(common.HeaderContextPrefix+"Hops", "c1")
+	req.Header.Set(common.HeaderContextPrefix+"Deadline", "1")
+	req.Header.Set("traceparent", "00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01")
+
+	ctx := httpRequestContext(req)
+	want := common.Context{
+		"user_id":                    "1",
+		common.ContextKeyTraceParent: "00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01",
+	}
+	if len(ctx) != len(want) {
+		t.Errorf("context = %v, want %v", ctx, want)
+	}
+	for k, v := range want {
+		if ctx[k] != v {
+			t.Errorf("context[%s] = %v, want %v", k, ctx[k], v)
+		}
+	}
+}
+
+// 调用方, center和服务节点的span属于同一个trace, 并且依次是父子关系
+func TestTracePropagation(t *testing.T) {
+	exporter := tracing.NewMemoryExporter(0)
+
+	addr := freeAddr(t)
+	conf := common.ConfigCenter{Service: common.Service{Version: "v1", Name: "center"}, RpcPort: addr, HttpPort: freeAddr(t)}
+	c, err := NewCenter(conf, "", &loger.MyLoger{}, nil, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	c.SetTracer(tracing.NewTracer("center", exporter))
+	StartCenter(context.Background(), c)
+	defer c.Shutdown(context.Background())
+
+	pay := startTestNode(t, "pay", addr, func(ag *ApiInfoGroup) {
+		ag.RegisterCaller("ping", func(req *common.Request, res *common.Response) { res.SetOkResult("pong") })
+	})
+	defer StopNode(pay)
+	cli := startTestNode(t, "cli", addr, nil)
+	defer StopNode(cli)
+	pay.SetTracer(tracing.NewTracer("pay", exporter))
+	cli.SetTracer(tracing.NewTracer("cli", exporter))
+
+	parent := tracing.NewTracer("app", exporter).StartSpan("request", tracing.SpanContext{})
+	ctx := tracing.ContextWithSpan(context.Background(), parent)
+	if err := cli.Invoke(ctx, common.NewMethod("v1", "pay", "ping"), nil, nil); err != nil {
+		t.Fatal(err)
+	}
+
+	waitFor(t, "spans", func() bool { return len(exporter.Trace(parent.TraceID)) == 3 })
+	spanOf := map[string]*tracing.Span{}
+	for _, span := range exporter.Trace(parent.TraceID) {
+		spanOf[span.Service] = span
+	}
+	for _, link := range [][2]string{{"cli", ""}, {"center", "cli"}, {"pay", "center"}} {
+		span, ok := spanOf[link[0]]
+		if !ok {
+			t.Fatalf("no span from %s", link[0])
+		}
+		parentID := parent.SpanID
+		if link[1] != "" {
+			parentID = spanOf[link[1]].SpanID
+		}
+		if span.ParentID != parentID {
+			t.Errorf("%s: parent = %s, want %s", link[0], span.ParentID, parentID)
+		}
+	}
+}
@@ -0,0 +1,158 @@
+package rpc
+
+import (
+	"fmt"
+	"gitlab.forceup.in/zengliang/rpc2-center/common"
+	"math/rand"
+	"strings"
+	"sync"
+	"sync/atomic"
+)
+
+type (
+	trafficRule struct {
+		conf   common.TrafficRule
+		values map[string]bool
+		total  int
+
+		hits       int64
+		targetHits []int64
+	}
+
+	// 按流量规则修改请求的版本和tag
+	trafficRouter struct {
+		rwMu  sync.RWMutex
+		rules []*trafficRule
+	}
+)
+
+func newTrafficRule(conf common.TrafficRule) (*trafficRule, error) {
+	if conf.Service == "" {
+		return nil, fmt.Errorf("traffic rule %s: service is empty", conf.Name)
+	}
+
+	rule := &trafficRule{
+		conf:       conf,
+		values:     make(map[string]bool),
+		targetHits: make([]int64, len(conf.Targets)),
+	}
+	rule.conf.Service = strings.ToLower(conf.Service)
+	for _, v := range conf.MatchValues {
+		rule.values[v] = true
+	}
+	for _, target := range conf.Targets {
+		if target.Weight < 0 {
+			return nil, fmt.Errorf("traffic rule %s: negative weight", conf.Name)
+		}
+		rule.total += target.Weight
+	}
+	if rule.total == 0 {
+		return nil, fmt.Errorf("traffic rule %s: no target weight", conf.Name)
+	}
+	return rule, nil
+}
+
+func newTrafficRouter(rules []common.TrafficRule) (*trafficRouter, error) {
+	tr := &trafficRouter{}
+	if err := tr.set(rules); err != nil {
+		return nil, err
+	}
+	return tr, nil
+}
+
+// 替换所有规则, 命中次数重新统计
+func (tr *trafficRouter) set(confs []common.TrafficRule) error {
+	rules := make([]*trafficRule, 0, len(confs))
+	for _, conf := range confs {
+		rule, err := newTrafficRule(conf)
+		if err != nil {
+			return err
+		}
+		rules = append(rules, rule)
+	}
+
+	tr.rwMu.Lock()
+	defer tr.rwMu.Unlock()
+	tr.rules = rules
+	return nil
+}
+
+func (tr *trafficRouter) status() []common.TrafficRuleStatus {
+	tr.rwMu.RLock()
+	defer tr.rwMu.RUnlock()
+
+	status := make([]common.TrafficRuleStatus, 0, len(tr.rules))
+	for _, rule := range tr.rules {
+		s := common.TrafficRuleStatus{
+			TrafficRule: rule.conf,
+			Hits:        atomic.LoadInt64(&rule.hits),
+			TargetHits:  make([]int64, len(rule.targetHits)),
+		}
+		for i := range rule.targetHits {
+			s.TargetHits[i] = atomic.LoadInt64(&rule.targetHits[i])
+		}
+		status = append(status, s)
+	}
+	return status
+}
+
+// 按第一条匹配的规则修改请求, 指定了tag的请求不处理
+func (tr *trafficRouter) route(req *common.Request) {
+	if req.Method.Tag != "" {
+		return
+	}
+
+	tr.rwMu.RLock()
+	defer tr.rwMu.RUnlock()
+
+	srvKey := req.Method.GetKey()
+	for _, rule := range tr.rules {
+		if rule.conf.Service != srvKey || !rule.match(req) {
+			continue
+		}
+
+		i := rule.pick()
+		atomic.AddInt64(&rule.hits, 1)
+		atomic.AddInt64(&rule.targetHits[i], 1)
+
+		target := rule.conf.Targets[i]
+		if target.Version != "" {
+			req.Method.Version = target.Version
+		}
+		if target.Tag != "" {
+			req.Method.Tag = target.Tag
+		}
+		return
+	}
+}
+
+func (rule *trafficRule) match(req *common.Request) bool {
+	if rule.conf.MatchKey == "" {
+		return true
+	}
+
+	v, ok := req.Context[rule.conf.MatchKey]
+	return ok && rule.values[fmt.Sprint(v)]
+}
+
+// 按权重随机选择一个目标
+func (rule *trafficRule) pick() int {
+	n := rand.Intn(rule.total)
+	for i, target := range rule.conf.Targets {
+		if n < target.Weight {
+			return i
+		}
+		n -= target.Weight
+	}
+	return len(rule.conf.Targets) - 1
+}
+
+// 运行时修改流量规则, 规则无效时不修改
+func (c *Center) SetTrafficRules(rules []common.TrafficRule) error {
+	return c.traffic.set(rules)
+}
+
+// 当前的流量规则和命中次数
+func (c *Center) GetTrafficRules() []common.TrafficRuleStatus {
+	return c.traffic.status()
+}
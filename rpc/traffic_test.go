@@ -0,0 +1,81 @@
+package rpc
+
+import (
+	"gitlab.forceup.in/zengliang/rpc2-center/common"
+	"testing"
+)
+
+func TestNewTrafficRule(t *testing.T) {
+	tests := []struct {
+		name string
+		conf common.TrafficRule
+		ok   bool
+	}{
+		{"valid", common.TrafficRule{Service: "v1.pay", Targets: []common.TrafficTarget{{Version: "v2", Weight: 1}}}, true},
+		{"empty service", common.TrafficRule{Targets: []common.TrafficTarget{{Version: "v2", Weight: 1}}}, false},
+		{"negative weight", common.TrafficRule{Service: "v1.pay", Targets: []common.TrafficTarget{{Weight: -1}, {Weight: 2}}}, false},
+		{"no weight", common.TrafficRule{Service: "v1.pay", Targets: []common.TrafficTarget{{Version: "v2"}}}, false},
+	}
+
+	for _, tt := range tests {
+		if _, err := newTrafficRule(tt.conf); (err == nil) != tt.ok {
+			t.Errorf("%s: newTrafficRule() = %v, want ok %v", tt.name, err, tt.ok)
+		}
+	}
+}
+
+func TestTrafficRoute(t *testing.T) {
+	tr, err := newTrafficRouter([]common.TrafficRule{
+		{Name: "beta", Service: "V1.Pay", MatchKey: "uid", MatchValues: []string{"1", "2"},
+			Targets: []common.TrafficTarget{{Version: "v2", Tag: "beta", Weight: 1}}},
+		{Name: "canary", Service: "v1.pay",
+			Targets: []common.TrafficTarget{{Tag: "stable", Weight: 1}, {Tag: "canary"}}},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name        string
+		method      common.Method
+		context     common.Context
+		wantVersion string
+		wantTag     string
+	}{
+		{"match value", common.NewMethod("v1", "pay", "charge"), common.Context{"uid": 1}, "v2", "beta"},
+		{"not match value", common.NewMethod("v1", "pay", "charge"), common.Context{"uid": 3}, "v1", "stable"},
+		{"no match key", common.NewMethod("v1", "pay", "charge"), nil, "v1", "stable"},
+		{"other service", common.NewMethod("v1", "user", "get"), common.Context{"uid": 1}, "v1", ""},
+		{"tag specified", common.Method{Service: common.Service{Version: "v1", Name: "pay", Tag: "a"}, Function: "charge"},
+			common.Context{"uid": 1}, "v1", "a"},
+	}
+
+	for _, tt := range tests {
+		req := &common.Request{Method: tt.method, Context: tt.context}
+		tr.route(req)
+		if req.Method.Version != tt.wantVersion || req.Method.Tag != tt.wantTag {
+			t.Errorf("%s: routed to %s(%s), want %s(%s)", tt.name,
+				req.Method.Version, req.Method.Tag, tt.wantVersion, tt.wantTag)
+		}
+	}
+
+	// 每条规则和每个目标的命中次数
+	status := tr.status()
+	if len(status) != 2 {
+		t.Fatalf("status has %d rules, want 2", len(status))
+	}
+	if status[0].Hits != 1 || status[0].TargetHits[0] != 1 {
+		t.Errorf("beta: hits = %d %v, want 1 [1]", status[0].Hits, status[0].TargetHits)
+	}
+	if status[1].Hits != 2 || status[1].TargetHits[0] != 2 || status[1].TargetHits[1] != 0 {
+		t.Errorf("canary: hits = %d %v, want 2 [2 0]", status[1].Hits, status[1].TargetHits)
+	}
+
+	// 替换规则后重新统计
+	if err := tr.set([]common.TrafficRule{{Service: "v1.pay", Targets: []common.TrafficTarget{{Weight: 1}}}}); err != nil {
+		t.Fatal(err)
+	}
+	if status := tr.status(); len(status) != 1 || status[0].Hits != 0 {
+		t.Errorf("status after set = %+v", status)
+	}
+}
@@ -0,0 +1,157 @@
+package rpc
+
+import (
+	"gitlab.forceup.in/zengliang/rpc2-center/common"
+	"strconv"
+	"strings"
+)
+
+type (
+	// 服务版本, 格式为v1, v1.2, v1.2.3, 前缀v可以省略
+	semVersion struct {
+		nums  [3]int
+		parts int // 版本中给出的段数
+	}
+
+	// 请求的版本范围
+	// v1.2: 1.2.x; ^1.2: >=1.2.0 且主版本为1; ~1.2.3: >=1.2.3 且次版本为1.2
+	versionRange struct {
+		op  byte
+		ver semVersion
+	}
+)
+
+func parseVersion(s string) (semVersion, bool) {
+	v := semVersion{}
+
+	s = strings.TrimPrefix(strings.ToLower(s), "v")
+	ss := strings.Split(s, ".")
+	if s == "" || len(ss) > 3 {
+		return v, false
+	}
+	for i, part := range ss {
+		num, err := strconv.Atoi(part)
+		if err != nil || num < 0 {
+			return v, false
+		}
+		v.nums[i] = num
+	}
+	v.parts = len(ss)
+	return v, true
+}
+
+func (v semVersion) less(other semVersion) bool {
+	for i := range v.nums {
+		if v.nums[i] != other.nums[i] {
+			return v.nums[i] < other.nums[i]
+		}
+	}
+	return false
+}
+
+func parseVersionRange(s string) (versionRange, bool) {
+	r := versionRange{}
+	if strings.HasPrefix(s, "^") || strings.HasPrefix(s, "~") {
+		r.op = s[0]
+		s = s[1:]
+	}
+
+	var ok bool
+	r.ver, ok = parseVersion(s)
+	return r, ok
+}
+
+func (r versionRange) match(v semVersion) bool {
+	// 主版本都必须相同
+	if v.nums[0] != r.ver.nums[0] {
+		return false
+	}
+
+	switch r.op {
+	case '^':
+		return !v.less(r.ver)
+	case '~':
+		if r.ver.parts >= 2 && v.nums[1] != r.ver.nums[1] {
+			return false
+		}
+		return !v.less(r.ver)
+	}
+
+	for i := 1; i < r.ver.parts; i++ {
+		if v.nums[i] != r.ver.nums[i] {
+			return false
+		}
+	}
+	return true
+}
+
+// 选出满足范围的最高版本, 没有时返回false
+func resolveVersion(requested string, versions []string) (string, bool) {
+	r, ok := parseVersionRange(requested)
+	if !ok {
+		return "", false
+	}
+
+	var best string
+	var bestVer semVersion
+	for _, version := range versions {
+		v, ok := parseVersion(version)
+		if !ok || !r.match(v) {
+			continue
+		}
+		if best == "" || bestVer.less(v) {
+			best, bestVer = version, v
+		}
+	}
+	return best, best != ""
+}
+
+// 按版本策略把请求的版本替换为注册的兼容版本, 已有完全相同的版本时不替换
+func (c *Center) resolveRequestVersion(req *common.Request) {
+	if c.cfgCenter.VersionPolicy != common.VersionPolicyCompatible {
+		return
+	}
+
+	srvKey := req.Method.GetKey()
+	if srvKey == c.cfgCenter.GetKey() {
+		return
+	}
+
+	versions := []string{}
+	c.rwMu.RLock()
+	_, exists := c.verNameMapNodeGroup[srvKey]
+	for _, nodeGroup := range c.verNameMapNodeGroup {
+		if srv := nodeGroup.GetNodeInfo(); strings.EqualFold(srv.Name, req.Method.Name) {
+			versions = append(versions, srv.Version)
+		}
+	}
+	c.rwMu.RUnlock()
+
+	if exists {
+		return
+	}
+
+	for _, link := range c.peers.getAll() {
+		for _, reg := range link.getNodes() {
+			if reg.GetKey() == srvKey {
+				return
+			}
+			if strings.EqualFold(reg.Name, req.Method.Name) {
+				versions = append(versions, reg.Version)
+			}
+		}
+	}
+
+	if version, ok := resolveVersion(req.Method.Version, versions); ok {
+		c.Debug("resolve %s.%s -> %s", req.Method.Version, req.Method.Name, version)
+		req.Method.Version = version
+	}
+}
+
+// 在应答上下文中记录实际处理请求的服务版本
+func setServedVersion(res *common.Response, version string) {
+	if res.Context == nil {
+		res.Context = make(common.Context)
+	}
+	res.Context[common.ContextKeyServedVersion] = version
+}
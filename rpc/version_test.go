@@ -0,0 +1,96 @@
+package rpc
+
+import (
+	"testing"
+)
+
+func TestParseVersion(t *testing.T) {
+	tests := []struct {
+		in    string
+		nums  [3]int
+		parts int
+		ok    bool
+	}{
+		{"v1", [3]int{1, 0, 0}, 1, true},
+		{"V1.2", [3]int{1, 2, 0}, 2, true},
+		{"1.2.3", [3]int{1, 2, 3}, 3, true},
+		{"v", [3]int{}, 0, false},
+		{"", [3]int{}, 0, false},
+		{"v1.2.3.4", [3]int{}, 0, false},
+		{"v1.x", [3]int{}, 0, false},
+		{"v1.-2", [3]int{}, 0, false},
+	}
+
+	for _, tt := range tests {
+		v, ok := parseVersion(tt.in)
+		if ok != tt.ok {
+			t.Errorf("parseVersion(%q) ok = %v, want %v", tt.in, ok, tt.ok)
+			continue
+		}
+		if ok && (v.nums != tt.nums || v.parts != tt.parts) {
+			t.Errorf("parseVersion(%q) = %v/%d, want %v/%d", tt.in, v.nums, v.parts, tt.nums, tt.parts)
+		}
+	}
+}
+
+func TestVersionRangeMatch(t *testing.T) {
+	tests := []struct {
+		requested string
+		version   string
+		want      bool
+	}{
+		{"v1", "v1.9.9", true},
+		{"v1", "v2", false},
+		{"v1.2", "v1.2.7", true},
+		{"v1.2", "v1.3", false},
+		{"v1.2.3", "v1.2.3", true},
+		{"v1.2.3", "v1.2.4", false},
+		{"^1.2", "v1.2.0", true},
+		{"^1.2", "v1.9", true},
+		{"^1.2", "v1.1.9", false},
+		{"^1.2", "v2.0", false},
+		{"~1.2.3", "v1.2.9", true},
+		{"~1.2.3", "v1.2.2", false},
+		{"~1.2.3", "v1.3.0", false},
+		{"~1", "v1.5", true},
+	}
+
+	for _, tt := range tests {
+		r, ok := parseVersionRange(tt.requested)
+		if !ok {
+			t.Fatalf("parseVersionRange(%q) failed", tt.requested)
+		}
+		v, ok := parseVersion(tt.version)
+		if !ok {
+			t.Fatalf("parseVersion(%q) failed", tt.version)
+		}
+		if got := r.match(v); got != tt.want {
+			t.Errorf("%q match %q = %v, want %v", tt.requested, tt.version, got, tt.want)
+		}
+	}
+}
+
+func TestResolveVersion(t *testing.T) {
+	versions := []string{"v1", "v1.2", "v1.2.5", "v1.10", "v2.0", "bad"}
+
+	tests := []struct {
+		requested string
+		want      string
+		ok        bool
+	}{
+		{"v1", "v1.10", true},
+		{"v1.2", "v1.2.5", true},
+		{"^1.3", "v1.10", true},
+		{"~1.2", "v1.2.5", true},
+		{"v2", "v2.0", true},
+		{"v3", "", false},
+		{"x", "", false},
+	}
+
+	for _, tt := range tests {
+		got, ok := resolveVersion(tt.requested, versions)
+		if got != tt.want || ok != tt.ok {
+			t.Errorf("resolveVersion(%q) = %q, %v, want %q, %v", tt.requested, got, ok, tt.want, tt.ok)
+		}
+	}
+}
@@ -0,0 +1,230 @@
+package rpc
+
+import (
+	"fmt"
+	"github.com/zl03jsj/rpc2"
+	"gitlab.forceup.in/zengliang/rpc2-center/common"
+	"strings"
+	"sync"
+)
+
+type (
+	// 节点收到注册事件的回调
+	WatchCallBack func(event common.RegistryEvent)
+
+	// 节点上的一个订阅
+	nodeWatcher struct {
+		keys []string
+		cb   WatchCallBack
+	}
+
+	// 订阅了注册事件的节点连接
+	watcherGroup struct {
+		rwMu     sync.RWMutex
+		watchers map[*rpc2.Client]map[string]bool // 订阅的服务key(version.name), 为空时订阅所有服务
+	}
+)
+
+func newWatcherGroup() *watcherGroup {
+	return &watcherGroup{watchers: make(map[*rpc2.Client]map[string]bool)}
+}
+
+// 节点每次都发送全部订阅的key, 替换之前的订阅
+func (ws *watcherGroup) add(client *rpc2.Client, keys []string) {
+	ws.rwMu.Lock()
+	defer ws.rwMu.Unlock()
+
+	watched := make(map[string]bool)
+	for _, key := range keys {
+		watched[strings.ToLower(key)] = true
+	}
+	ws.watchers[client] = watched
+}
+
+func (ws *watcherGroup) remove(client *rpc2.Client) {
+	ws.rwMu.Lock()
+	defer ws.rwMu.Unlock()
+	delete(ws.watchers, client)
+}
+
+// 订阅了srvKey的连接
+func (ws *watcherGroup) find(srvKey string) []*rpc2.Client {
+	ws.rwMu.RLock()
+	defer ws.rwMu.RUnlock()
+
+	clients := []*rpc2.Client{}
+	for client, watched := range ws.watchers {
+		if len(watched) == 0 || watched[srvKey] {
+			clients = append(clients, client)
+		}
+	}
+	return clients
+}
+
+func watchMatch(keys []string, srvKey string) bool {
+	if len(keys) == 0 {
+		return true
+	}
+	for _, key := range keys {
+		if strings.ToLower(key) == srvKey {
+			return true
+		}
+	}
+	return false
+}
+
+// 订阅服务的注册事件, keys为空时订阅所有服务, 返回当前已注册的节点(包括注册在peer上的节点)
+func (c *Center) byWatch(client *rpc2.Client, keys *[]string, res *[]common.Register) error {
+	if !c.isAuthorizedClient(client) {
+		return common.NewCodeError(common.ErrRegisterDenied, "watch denied")
+	}
+
+	c.watchers.add(client, *keys)
+	c.Info("watch %s", strings.Join(*keys, ","))
+
+	*res = c.watchSnapshot(*keys)
+	return nil
+}
+
+func (c *Center) watchSnapshot(keys []string) []common.Register {
+	nodes := []common.Register{}
+
+	c.rwMu.RLock()
+	for srvKey, nodeGroup := range c.verNameMapNodeGroup {
+		if watchMatch(keys, srvKey) {
+			nodes = append(nodes, nodeGroup.GetNodes()...)
+		}
+	}
+	c.rwMu.RUnlock()
+
+	for _, reg := range c.peers.getNodes() {
+		if watchMatch(keys, reg.GetKey()) {
+			nodes = append(nodes, reg)
+		}
+	}
+	return nodes
+}
+
+// 本center上的节点注册或注销, 通知peer和订阅的节点
+func (c *Center) publishEvent(eventType string, reg *common.Register) {
+	c.publishPeerEvent(eventType, reg)
+	c.publishWatchEvent(&common.RegistryEvent{Type: eventType, Node: *reg})
+}
+
+func (c *Center) publishWatchEvents(eventType string, regs []common.Register) {
+	for _, reg := range regs {
+		c.publishWatchEvent(&common.RegistryEvent{Type: eventType, Node: reg})
+	}
+}
+
+func (c *Center) publishWatchEvent(event *common.RegistryEvent) {
+	for _, client := range c.watchers.find(event.Node.GetKey()) {
+		if err := client.Notify(common.MethodNodeWatch, event); err != nil {
+			c.Error("publish watch event: %s", err.Error())
+		}
+	}
+}
+
+// 订阅服务的注册事件, 先以注册事件回调当前已注册的节点, 重连center后自动重新订阅,
+// 可以多次调用, 每次的回调只收到keys中服务的事件
+func (n *Node) WatchServices(keys []string, cb WatchCallBack) error {
+	if cb == nil {
+		return fmt.Errorf("watch callback is nil")
+	}
+	watcher := &nodeWatcher{keys: keys, cb: cb}
+
+	n.rwMu.Lock()
+	n.watchers = append(n.watchers, watcher)
+	if n.watchNodes == nil {
+		n.watchNodes = make(map[string]common.Register)
+	}
+	nodes := []common.Register{}
+	for _, reg := range n.watchNodes {
+		if watchMatch(keys, reg.GetKey()) {
+			nodes = append(nodes, reg)
+		}
+	}
+	n.rwMu.Unlock()
+
+	for _, reg := range nodes {
+		cb(common.RegistryEvent{Type: common.EventRegister, Node: reg})
+	}
+
+	var err error
+	for _, client := range n.getClients() {
+		if e := n.watchCenter(client); e != nil {
+			err = e
+		}
+	}
+	return err
+}
+
+// 所有订阅的服务key, 有订阅全部服务的返回nil
+func (n *Node) watchKeys() ([]string, bool) {
+	n.rwMu.RLock()
+	defer n.rwMu.RUnlock()
+
+	if len(n.watchers) == 0 {
+		return nil, false
+	}
+
+	keys := []string{}
+	for _, watcher := range n.watchers {
+		if len(watcher.keys) == 0 {
+			return nil, true
+		}
+		keys = append(keys, watcher.keys...)
+	}
+	return keys, true
+}
+
+func (n *Node) watchCenter(client *rpc2.Client) error {
+	keys, ok := n.watchKeys()
+	if !ok {
+		return nil
+	}
+
+	nodes := []common.Register{}
+	if err := client.Call(common.MethodCenterWatch, keys, &nodes); err != nil {
+		n.Error("watch err: %s", err.Error())
+		return err
+	}
+
+	for _, reg := range nodes {
+		n.dispatchWatchEvent(common.RegistryEvent{Type: common.EventRegister, Node: reg})
+	}
+	return nil
+}
+
+func (n *Node) byWatchEvent(client *rpc2.Client, event *common.RegistryEvent, res *string) error {
+	n.dispatchWatchEvent(*event)
+	return nil
+}
+
+// 连接了多个center或center之间有peer时, 同一个事件会从每个center收到一次,
+// 按节点id去掉重复的事件后回调订阅了该服务的watcher
+func (n *Node) dispatchWatchEvent(event common.RegistryEvent) {
+	n.rwMu.Lock()
+	if n.watchNodes == nil {
+		n.watchNodes = make(map[string]common.Register)
+	}
+	_, exists := n.watchNodes[event.Node.Id]
+	if event.Type == common.EventUnRegister {
+		delete(n.watchNodes, event.Node.Id)
+	} else {
+		n.watchNodes[event.Node.Id] = event.Node
+	}
+	watchers := n.watchers
+	n.rwMu.Unlock()
+
+	if exists == (event.Type == common.EventRegister) {
+		return
+	}
+
+	srvKey := event.Node.GetKey()
+	for _, watcher := range watchers {
+		if watchMatch(watcher.keys, srvKey) {
+			watcher.cb(event)
+		}
+	}
+}
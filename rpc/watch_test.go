@@ -0,0 +1,116 @@
+package rpc
+
+import (
+	"github.com/zl03jsj/rpc2"
+	"gitlab.forceup.in/zengliang/rpc2-center/common"
+	"gitlab.forceup.in/zengliang/rpc2-center/loger"
+	"testing"
+)
+
+func newWatchEvent(eventType, name, id string) common.RegistryEvent {
+	return common.RegistryEvent{Type: eventType, Node: common.Register{
+		Service: common.Service{Version: "v1", Name: name},
+		Id:      id,
+	}}
+}
+
+func TestWatcherGroup(t *testing.T) {
+	ws := newWatcherGroup()
+	all, pay := &rpc2.Client{}, &rpc2.Client{}
+	ws.add(all, nil)
+	ws.add(pay, []string{"V1.Pay"})
+
+	if got := ws.find("v1.pay"); len(got) != 2 {
+		t.Errorf("find(v1.pay) = %d clients, want 2", len(got))
+	}
+	if got := ws.find("v1.user"); len(got) != 1 || got[0] != all {
+		t.Errorf("find(v1.user) should only return the client watching all services")
+	}
+
+	// 再次订阅替换之前的key
+	ws.add(pay, []string{"v1.user"})
+	if got := ws.find("v1.pay"); len(got) != 1 || got[0] != all {
+		t.Errorf("find(v1.pay) should not return the replaced watcher")
+	}
+
+	ws.remove(all)
+	ws.remove(pay)
+	if got := ws.find("v1.user"); len(got) != 0 {
+		t.Errorf("find(v1.user) = %d clients after remove", len(got))
+	}
+}
+
+// 同一个节点的事件从多个center收到时只回调一次
+func TestDispatchWatchEvent(t *testing.T) {
+	n := &Node{ILoger: &loger.MyLoger{}}
+	events := map[string][]string{}
+	record := func(name string) WatchCallBack {
+		return func(event common.RegistryEvent) {
+			events[name] = append(events[name], event.Type+":"+event.Node.Id)
+		}
+	}
+	n.WatchServices(nil, record("all"))
+	n.WatchServices([]string{"v1.pay"}, record("pay"))
+
+	for _, event := range []common.RegistryEvent{
+		newWatchEvent(common.EventRegister, "pay", "a"),
+		newWatchEvent(common.EventRegister, "pay", "a"),
+		newWatchEvent(common.EventRegister, "user", "b"),
+		newWatchEvent(common.EventUnRegister, "pay", "a"),
+		newWatchEvent(common.EventUnRegister, "pay", "a"),
+	} {
+		n.dispatchWatchEvent(event)
+	}
+
+	want := map[string][]string{
+		"all": {common.EventRegister + ":a", common.EventRegister + ":b", common.EventUnRegister + ":a"},
+		"pay": {common.EventRegister + ":a", common.EventUnRegister + ":a"},
+	}
+	for name, want := range want {
+		if !equalStrings(events[name], want) {
+			t.Errorf("%s: events = %v, want %v", name, events[name], want)
+		}
+	}
+
+	// 新的订阅先收到已经注册的节点
+	replay := []string{}
+	n.WatchServices([]string{"v1.user"}, func(event common.RegistryEvent) {
+		replay = append(replay, event.Node.Id)
+	})
+	if !equalStrings(replay, []string{"b"}) {
+		t.Errorf("replay = %v, want [b]", replay)
+	}
+}
+
+// 两个center互相配置为peer时快照中的节点不重复
+func TestWatchSnapshot(t *testing.T) {
+	c, err := NewCenter(common.ConfigCenter{}, "", &loger.MyLoger{}, nil, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	sng := &NodeGroup{ILoger: &loger.MyLoger{}}
+	sng.Register(&rpc2.Client{}, &common.Register{Service: common.Service{Version: "v1", Name: "pay"}, Id: "a"})
+	c.verNameMapNodeGroup["v1.pay"] = sng
+
+	snapshot := &common.PeerSnapshot{Center: common.Register{Id: "c1"}, Nodes: []common.Register{
+		newWatchEvent(common.EventRegister, "pay", "c1-1").Node,
+		newWatchEvent(common.EventRegister, "user", "c1-2").Node,
+	}}
+	c.peers.add(&rpc2.Client{}, "127.0.0.1:7081").sync(snapshot)
+	c.peers.add(&rpc2.Client{}, "").sync(snapshot)
+
+	tests := []struct {
+		keys []string
+		want []string
+	}{
+		{nil, []string{"a", "c1-1", "c1-2"}},
+		{[]string{"V1.Pay"}, []string{"a", "c1-1"}},
+		{[]string{"v1.order"}, []string{}},
+	}
+	for _, tt := range tests {
+		if got := nodeIds(c.watchSnapshot(tt.keys)); !equalStrings(got, tt.want) {
+			t.Errorf("watchSnapshot(%v) = %v, want %v", tt.keys, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,58 @@
+package tools
+
+import (
+	"math/rand"
+	"time"
+)
+
+// 指数退避, 非并发安全
+type Backoff struct {
+	initial    time.Duration
+	max        time.Duration
+	multiplier float64
+	jitter     float64
+
+	attempt int
+	current time.Duration
+}
+
+func NewBackoff(initial, max time.Duration, multiplier, jitter float64) *Backoff {
+	return &Backoff{
+		initial:    initial,
+		max:        max,
+		multiplier: multiplier,
+		jitter:     jitter,
+	}
+}
+
+// 下一次等待的时间, 在[d*(1-jitter), d*(1+jitter)]之间随机, 不超过max
+func (b *Backoff) Next() time.Duration {
+	if b.attempt == 0 {
+		b.current = b.initial
+	} else {
+		b.current = time.Duration(float64(b.current) * b.multiplier)
+	}
+	if b.current > b.max {
+		b.current = b.max
+	}
+	b.attempt++
+
+	d := b.current
+	if b.jitter > 0 {
+		d = time.Duration(float64(d) * (1 + b.jitter*(rand.Float64()*2-1)))
+	}
+	if d > b.max {
+		d = b.max
+	}
+	return d
+}
+
+// 已经退避的次数
+func (b *Backoff) Attempt() int {
+	return b.attempt
+}
+
+func (b *Backoff) Reset() {
+	b.attempt = 0
+	b.current = 0
+}
@@ -34,3 +34,12 @@ func ParseMeta(meta string) map[string]string {
 
 	return values
 }
+
+// 从GetDateNowString格式的启动时间到现在的秒数, 格式错误时返回0
+func GetUptime(startAt string) int64 {
+	t, err := time.ParseInLocation("2006-01-02 15:04:05", startAt, time.Local)
+	if err != nil {
+		return 0
+	}
+	return int64(time.Since(t).Seconds())
+}
@@ -0,0 +1,92 @@
+package tracing
+
+import (
+	"encoding/json"
+	"os"
+	"sync"
+)
+
+type (
+	// 保存最近的span, 用于调试和测试
+	MemoryExporter struct {
+		mu    sync.Mutex
+		max   int
+		spans []*Span
+	}
+
+	// 每个span一行json写入文件
+	FileExporter struct {
+		mu      sync.Mutex
+		file    *os.File
+		encoder *json.Encoder
+	}
+)
+
+// max小于等于0时不限制数量
+func NewMemoryExporter(max int) *MemoryExporter {
+	return &MemoryExporter{max: max}
+}
+
+func (e *MemoryExporter) Export(span *Span) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	e.spans = append(e.spans, span)
+	if e.max > 0 && len(e.spans) > e.max {
+		e.spans = e.spans[len(e.spans)-e.max:]
+	}
+}
+
+func (e *MemoryExporter) Spans() []*Span {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	return append([]*Span{}, e.spans...)
+}
+
+// 获取同一个trace的所有span
+func (e *MemoryExporter) Trace(traceID string) []*Span {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	spans := []*Span{}
+	for _, span := range e.spans {
+		if span.TraceID == traceID {
+			spans = append(spans, span)
+		}
+	}
+	return spans
+}
+
+func (e *MemoryExporter) Reset() {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	e.spans = nil
+}
+
+func NewFileExporter(path string) (*FileExporter, error) {
+	file, err := os.OpenFile(path, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0644)
+	if err != nil {
+		return nil, err
+	}
+
+	return &FileExporter{file: file, encoder: json.NewEncoder(file)}, nil
+}
+
+func (e *FileExporter) Export(span *Span) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	span.mu.Lock()
+	defer span.mu.Unlock()
+
+	e.encoder.Encode(span)
+}
+
+func (e *FileExporter) Close() error {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	return e.file.Close()
+}
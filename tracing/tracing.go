@@ -0,0 +1,207 @@
+package tracing
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"fmt"
+	"gitlab.forceup.in/zengliang/rpc2-center/common"
+	"strings"
+	"sync"
+	"time"
+)
+
+// W3C trace context(https://www.w3.org/TR/trace-context/)的传播和span记录
+type (
+	SpanContext struct {
+		TraceID string
+		SpanID  string
+		Flags   byte
+		State   string
+	}
+
+	Span struct {
+		TraceID    string            `json:"trace_id"`
+		SpanID     string            `json:"span_id"`
+		ParentID   string            `json:"parent_id,omitempty"`
+		Name       string            `json:"name"`
+		Service    string            `json:"service"`
+		Start      time.Time         `json:"start"`
+		End        time.Time         `json:"end"`
+		Attributes map[string]string `json:"attributes,omitempty"`
+		Error      string            `json:"error,omitempty"`
+
+		mu     sync.Mutex
+		flags  byte
+		state  string
+		tracer *Tracer
+	}
+
+	// span结束后导出
+	Exporter interface {
+		Export(span *Span)
+	}
+
+	// 为nil时不记录span, 也不修改请求中的trace context
+	Tracer struct {
+		service  string
+		exporter Exporter
+	}
+
+	spanKey struct{}
+)
+
+const traceparentVersion = "00"
+
+func NewTracer(service string, exporter Exporter) *Tracer {
+	return &Tracer{service: service, exporter: exporter}
+}
+
+// 解析traceparent: version-traceid-spanid-flags
+func ParseTraceparent(traceparent string) (SpanContext, bool) {
+	parts := strings.Split(strings.TrimSpace(traceparent), "-")
+	if len(parts) < 4 || len(parts[0]) != 2 || parts[0] == "ff" {
+		return SpanContext{}, false
+	}
+	if len(parts[1]) != 32 || len(parts[2]) != 16 || len(parts[3]) != 2 {
+		return SpanContext{}, false
+	}
+
+	flags, err := hex.DecodeString(parts[3])
+	if err != nil || !isHex(parts[1]) || !isHex(parts[2]) {
+		return SpanContext{}, false
+	}
+
+	sc := SpanContext{TraceID: parts[1], SpanID: parts[2], Flags: flags[0]}
+	return sc, sc.IsValid()
+}
+
+func (sc SpanContext) IsValid() bool {
+	return sc.TraceID != "" && sc.TraceID != strings.Repeat("0", 32) &&
+		sc.SpanID != "" && sc.SpanID != strings.Repeat("0", 16)
+}
+
+func (sc SpanContext) Traceparent() string {
+	return fmt.Sprintf("%s-%s-%s-%02x", traceparentVersion, sc.TraceID, sc.SpanID, sc.Flags)
+}
+
+// 从请求上下文中取trace context
+func Extract(req *common.Request) SpanContext {
+	traceparent, _ := req.Context[common.ContextKeyTraceParent].(string)
+	sc, ok := ParseTraceparent(traceparent)
+	if !ok {
+		return SpanContext{}
+	}
+	sc.State, _ = req.Context[common.ContextKeyTraceState].(string)
+	return sc
+}
+
+// 把trace context写入请求上下文
+func Inject(req *common.Request, sc SpanContext) {
+	if !sc.IsValid() {
+		return
+	}
+	if req.Context == nil {
+		req.Context = make(common.Context)
+	}
+	req.Context[common.ContextKeyTraceParent] = sc.Traceparent()
+	if sc.State != "" {
+		req.Context[common.ContextKeyTraceState] = sc.State
+	}
+}
+
+// 把span放入context, 在处理函数中继续发起的调用作为它的子span
+func ContextWithSpan(ctx context.Context, span *Span) context.Context {
+	if span == nil {
+		return ctx
+	}
+	return context.WithValue(ctx, spanKey{}, span)
+}
+
+func SpanFromContext(ctx context.Context) *Span {
+	span, _ := ctx.Value(spanKey{}).(*Span)
+	return span
+}
+
+// 开始一个span, parent无效时开始新的trace
+func (t *Tracer) StartSpan(name string, parent SpanContext) *Span {
+	if t == nil {
+		return nil
+	}
+
+	span := &Span{
+		SpanID:  newID(8),
+		Name:    name,
+		Service: t.service,
+		Start:   time.Now(),
+		flags:   1,
+		tracer:  t,
+	}
+	if parent.IsValid() {
+		span.TraceID = parent.TraceID
+		span.ParentID = parent.SpanID
+		span.flags = parent.Flags
+		span.state = parent.State
+	} else {
+		span.TraceID = newID(16)
+	}
+	return span
+}
+
+func (s *Span) Context() SpanContext {
+	if s == nil {
+		return SpanContext{}
+	}
+	return SpanContext{TraceID: s.TraceID, SpanID: s.SpanID, Flags: s.flags, State: s.state}
+}
+
+func (s *Span) SetAttribute(key, value string) {
+	if s == nil {
+		return
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.Attributes == nil {
+		s.Attributes = make(map[string]string)
+	}
+	s.Attributes[key] = value
+}
+
+func (s *Span) SetError(err_fmt string, args ...interface{}) {
+	if s == nil {
+		return
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.Error = fmt.Sprintf(err_fmt, args...)
+}
+
+// 结束span并导出
+func (s *Span) Finish() {
+	if s == nil {
+		return
+	}
+
+	s.mu.Lock()
+	s.End = time.Now()
+	s.mu.Unlock()
+
+	if s.tracer.exporter != nil {
+		s.tracer.exporter.Export(s)
+	}
+}
+
+func newID(n int) string {
+	b := make([]byte, n)
+	rand.Read(b)
+	return hex.EncodeToString(b)
+}
+
+func isHex(s string) bool {
+	_, err := hex.DecodeString(s)
+	return err == nil
+}
@@ -0,0 +1,89 @@
+package tracing
+
+import (
+	"gitlab.forceup.in/zengliang/rpc2-center/common"
+	"testing"
+)
+
+const (
+	testTraceID = "4bf92f3577b34da6a3ce929d0e0e4736"
+	testSpanID  = "00f067aa0ba902b7"
+)
+
+func TestParseTraceparent(t *testing.T) {
+	tests := []struct {
+		traceparent string
+		ok          bool
+	}{
+		{"00-" + testTraceID + "-" + testSpanID + "-01", true},
+		{" 00-" + testTraceID + "-" + testSpanID + "-00 ", true},
+		{"01-" + testTraceID + "-" + testSpanID + "-01-extra", true},
+		{"ff-" + testTraceID + "-" + testSpanID + "-01", false},
+		{"00-" + testTraceID + "-" + testSpanID, false},
+		{"00-" + testTraceID[1:] + "-" + testSpanID + "-01", false},
+		{"00-" + "00000000000000000000000000000000" + "-" + testSpanID + "-01", false},
+		{"00-" + testTraceID + "-" + "0000000000000000" + "-01", false},
+		{"00-" + testTraceID + "-" + "zzf067aa0ba902b7" + "-01", false},
+		{"", false},
+	}
+
+	for _, tt := range tests {
+		sc, ok := ParseTraceparent(tt.traceparent)
+		if ok != tt.ok {
+			t.Errorf("ParseTraceparent(%q) ok = %v, want %v", tt.traceparent, ok, tt.ok)
+		}
+		if ok && (sc.TraceID != testTraceID || sc.SpanID != testSpanID) {
+			t.Errorf("ParseTraceparent(%q) = %+v", tt.traceparent, sc)
+		}
+	}
+}
+
+func TestInjectExtract(t *testing.T) {
+	sc := SpanContext{TraceID: testTraceID, SpanID: testSpanID, Flags: 1, State: "k=v"}
+	req := &common.Request{}
+	Inject(req, sc)
+	if got := req.Context[common.ContextKeyTraceParent]; got != "00-"+testTraceID+"-"+testSpanID+"-01" {
+		t.Errorf("traceparent = %v", got)
+	}
+	if got := Extract(req); got != sc {
+		t.Errorf("Extract() = %+v, want %+v", got, sc)
+	}
+
+	// 无效的trace context不写入请求
+	req = &common.Request{}
+	Inject(req, SpanContext{})
+	if len(req.Context) != 0 || Extract(req).IsValid() {
+		t.Errorf("invalid span context should not be injected")
+	}
+}
+
+func TestStartSpan(t *testing.T) {
+	exporter := NewMemoryExporter(2)
+	tracer := NewTracer("v1.pay", exporter)
+
+	root := tracer.StartSpan("root", SpanContext{})
+	child := tracer.StartSpan("child", root.Context())
+	if child.TraceID != root.TraceID || child.ParentID != root.SpanID || child.SpanID == root.SpanID {
+		t.Errorf("child %+v is not a child of %+v", child.Context(), root.Context())
+	}
+
+	remote := tracer.StartSpan("remote", SpanContext{TraceID: testTraceID, SpanID: testSpanID, State: "k=v"})
+	if remote.TraceID != testTraceID || remote.ParentID != testSpanID || remote.Context().State != "k=v" {
+		t.Errorf("remote parent is not kept: %+v", remote.Context())
+	}
+
+	root.Finish()
+	child.Finish()
+	remote.Finish()
+	if spans := exporter.Spans(); len(spans) != 2 || spans[0] != child || spans[1] != remote {
+		t.Errorf("exporter should keep the latest 2 spans")
+	}
+	if spans := exporter.Trace(testTraceID); len(spans) != 1 || spans[0] != remote {
+		t.Errorf("Trace() = %v", spans)
+	}
+
+	var nilTracer *Tracer
+	if span := nilTracer.StartSpan("none", root.Context()); span != nil || span.Context().IsValid() {
+		t.Errorf("nil tracer should not start span")
+	}
+}